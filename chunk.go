@@ -1,214 +1,365 @@
 package xmlx
 
 import (
-	"encoding/xml"
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 )
 
 // Chunk returns the start and stop position of the first encountered token.
+// The offset may fall anywhere in the reader, such as within a tag, a comment
+// or a CDATA section: the reader is first resynchronised, as Resync does, so
+// that the returned token is a genuine one. Tokens nested in a token enclosing
+// the offset are skipped, as long as the enclosing token closes within the
+// 64 KiB that follow them.
 func Chunk(reader io.ReadSeeker, token string, offset int64) ([2]int64, error) {
+	return ChunkContext(context.Background(), reader, token, offset)
+}
 
-	// Return the error whatever it is: an EOF means there is no node corresponding
-	// to the token.
-	start, err := nextStartOffset(reader, token, offset)
-	if err != nil {
-		return [2]int64{}, err
+// ChunkContext works as Chunk, and stops with the error of the context as soon
+// as it is done.
+func ChunkContext(ctx context.Context, reader io.ReadSeeker, token string, offset int64) ([2]int64, error) {
+
+	if offset > 0 {
+		start, err := Resync(reader, offset)
+		if err != nil {
+			return [2]int64{}, err
+		}
+		_, err = reader.Seek(start, io.SeekStart)
+		if err != nil {
+			return [2]int64{}, err
+		}
+		return chunkFrom(ctx, reader, token, start)
 	}
 
-	stop, err := nextStopOffset(reader, token, start)
+	_, err := reader.Seek(0, io.SeekStart)
 	if err != nil {
 		return [2]int64{}, err
 	}
 
-	return [2]int64{start, stop}, nil
+	// Return the error whatever it is: an EOF means there is no node corresponding
+	// to the token.
+	var segment [2]int64
+	err = scanTokens(ctx, reader, token, func(start, stop int64) error {
+		segment = [2]int64{start, stop}
+		return errStopped
+	})
+	switch err {
+	case errStopped:
+		return segment, nil
+	case nil:
+		return [2]int64{}, io.EOF
+	}
+
+	return [2]int64{}, err
+}
+
+// SamplingStrategy defines where ChunkAll samples the records used to estimate
+// their average size.
+type SamplingStrategy int
+
+// Sampling strategies. RandomSampling samples the first record, then random
+// records. EvenSampling samples evenly spaced records. HeadSampling samples the
+// first records.
+const (
+	RandomSampling SamplingStrategy = iota
+	EvenSampling
+	HeadSampling
+)
+
+// ChunkOptions defines how ChunkAll estimates the size of the records. The zero
+// ChunkOptions samples 10 random records, drawn from a source seeded with 0, so
+// segments are the same on every run.
+type ChunkOptions struct {
+
+	// The number of sampled records, 10 by default
+	Samples int
+
+	// The strategy choosing the sampled records
+	Strategy SamplingStrategy
+
+	// The source of the random records. When nil, a source seeded with Seed is
+	// created for each call. A source must not be shared between goroutines.
+	Source rand.Source
+
+	// The seed of the random records, when no source is provided
+	Seed int64
 }
 
 // ChunkAll reads the reader and defines a list of segments that correspond to valid chunks.
 // Each segment, except for the last one, contains a number of chunks superior or equal to the
-// provided bulkLen value.
+// provided bulkLen value. Since the segments are derived from an estimated size of the chunks,
+// use ChunkAllExact to get a fixed number of chunks per segment.
+//
+// The reader is read twice from its start: once to sample the size of the chunks, and once to
+// define the segments. Besides the segments, the memory used does not depend on the number of
+// chunks.
 func ChunkAll(reader io.ReadSeeker, token string, bulkLen int) ([][2]int64, error) {
-	var segments [][2]int64
-	var start, stop int64
+	return ChunkAllWithContext(context.Background(), reader, token, bulkLen, ChunkOptions{})
+}
 
-	size, err := guessTokenSize(reader, token, 10)
+// ChunkAllContext works as ChunkAll, and stops with the error of the context as
+// soon as it is done. The context is checked between the tokens of the reader.
+func ChunkAllContext(ctx context.Context, reader io.ReadSeeker, token string, bulkLen int) ([][2]int64, error) {
+	return ChunkAllWithContext(ctx, reader, token, bulkLen, ChunkOptions{})
+}
+
+// ChunkAllWith works as ChunkAll, with the size of the records estimated as
+// defined by the options. Segments are the same for a given reader and options.
+func ChunkAllWith(reader io.ReadSeeker, token string, bulkLen int, o ChunkOptions) ([][2]int64, error) {
+	return ChunkAllWithContext(context.Background(), reader, token, bulkLen, o)
+}
+
+// ChunkAllWithContext works as ChunkAllWith and ChunkAllContext.
+func ChunkAllWithContext(ctx context.Context, reader io.ReadSeeker, token string, bulkLen int, o ChunkOptions) ([][2]int64, error) {
+
+	if bulkLen < 1 {
+		bulkLen = 1
+	}
+
+	_, err := reader.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
-	size *= int64(bulkLen)
 
-	EOF, err := reader.Seek(0, 2)
+	sampler := newTokenSampler(o)
+	err = scanTokens(ctx, reader, token, sampler.add)
+	if err != nil && err != errStopped {
+		return nil, err
+	}
+	if sampler.count == 0 {
+		return nil, fmt.Errorf("cannot find token \"%s\" in reader", token)
+	}
+	size := sampler.size() * int64(bulkLen)
+
+	_, err = reader.Seek(0, io.SeekStart)
 	if err != nil {
 		return nil, err
 	}
 
-	// Calculate segments until the end of the file.
-	for err != io.EOF {
+	// Each segment ends with the first token ending after the estimated size of
+	// the segment, and holds at least bulkLen tokens.
+	segments, _, err := chunkAllFull(ctx, reader, token, func(segment [2]int64, count int) bool {
+		return count >= bulkLen && segment[1]-segment[0] >= size
+	})
+	return segments, err
+}
 
-		start, err = nextStartOffset(reader, token, stop)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
+// ChunkAllExact reads the reader once and defines a list of segments holding exactly
+// bulkLen tokens each, except for the last one which may hold less. The number of
+// tokens of each segment is returned along with the segments. Tokens nested in
+// a token are part of it, and are not counted on their own.
+func ChunkAllExact(reader io.Reader, token string, bulkLen int) ([][2]int64, []int, error) {
+	return ChunkAllExactContext(context.Background(), reader, token, bulkLen)
+}
 
-		// Find next sto
-		jump := start + size
-		stop, err = nextStopOffset(reader, token, jump)
-		if err != nil {
-			if err != io.EOF {
-				return nil, err
-			}
-			stop, err = lastStopOffset(reader, token, start, EOF)
-			if err != nil {
-				return nil, err
-			}
-		}
+// ChunkAllExactContext works as ChunkAllExact, and stops with the error of the
+// context as soon as it is done.
+func ChunkAllExactContext(ctx context.Context, reader io.Reader, token string, bulkLen int) ([][2]int64, []int, error) {
 
-		segment := [2]int64{start, stop}
-		segments = append(segments, segment)
+	if bulkLen <= 0 {
+		return nil, nil, fmt.Errorf("invalid bulk length %d", bulkLen)
 	}
 
-	return segments, nil
+	return chunkAllFull(ctx, reader, token, countFull(bulkLen))
 }
 
-// guessTokenSize returns the size of one token found by the parser. If the parser could
-// not find the expected token, this function returns an error. The size is calculed as
-// the average size of random token found after n iterations.
-func guessTokenSize(reader io.ReadSeeker, token string, iteration int) (int64, error) {
+// ChunkAllBytes reads the reader once and defines a list of segments of at least
+// size bytes: each segment ends with the first token ending after its first size
+// bytes, so that tokens are never split. Only the last segment may be smaller.
+// The number of tokens of each segment is returned along with the segments.
+func ChunkAllBytes(reader io.Reader, token string, size int64) ([][2]int64, []int, error) {
+	return ChunkAllBytesContext(context.Background(), reader, token, size)
+}
 
-	var avgs []int64
+// ChunkAllBytesContext works as ChunkAllBytes, and stops with the error of the
+// context as soon as it is done.
+func ChunkAllBytesContext(ctx context.Context, reader io.Reader, token string, size int64) ([][2]int64, []int, error) {
 
-	// Get the len of the reader.
-	EOF, err := reader.Seek(0, 2)
-	if err != nil {
-		return 0, err
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("invalid segment size %d", size)
 	}
 
-	// Calculate an average size based on the number of iterations.
-	// Start at position 0 to quickly find the node if it is unique.
-	var pos int64
-	for i := 0; i < iteration; i++ {
-
-		_, err = reader.Seek(pos, 0)
-		if err != nil {
-			return 0, err
-		}
+	return chunkAllFull(ctx, reader, token, sizeFull(size))
+}
 
-		start, err := nextStartOffset(reader, token, pos)
-		if err != nil {
+// chunkAllFull reads the reader once and adds each token to the current segment.
+// A new segment is started once full returns true for the current one.
+func chunkAllFull(ctx context.Context, reader io.Reader, token string, full func([2]int64, int) bool) ([][2]int64, []int, error) {
 
-			if err != io.EOF {
-				return 0, nil
-			}
+	s := segmenter{full: full}
+	err := scanTokens(ctx, reader, token, func(start, stop int64) error {
+		s.add(start, stop)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-			// This condition ensures the token exists: if the encountered error is an io.EOF,
-			// the loop should continue. However, if an EOF is encountered when the position
-			// is still 0, it means that the reader could not find any token matching the parsers
-			// token.
-			if pos == 0 {
-				return 0, fmt.Errorf("cannot find token \"%s\" in reader", token)
-			}
+	if len(s.segments) == 0 {
+		return nil, nil, fmt.Errorf("cannot find token \"%s\" in reader", token)
+	}
 
-			continue
-		}
+	return s.segments, s.counts, nil
+}
 
-		stop, err := nextStopOffset(reader, token, start)
-		if err != nil {
-			continue
-		}
+// segmenter groups tokens into segments. A new segment is started once full
+// returns true for the current one.
+type segmenter struct {
+	full     func(segment [2]int64, count int) bool
+	segments [][2]int64
+	counts   []int
+}
 
-		avgs = append(avgs, stop-start)
+// add adds the token to the current segment, or to a new one.
+func (s *segmenter) add(start, stop int64) {
+	n := len(s.counts)
+	if n == 0 || s.full(s.segments[n-1], s.counts[n-1]) {
+		s.segments = append(s.segments, [2]int64{start, 0})
+		s.counts = append(s.counts, 0)
+		n++
+	}
+	s.segments[n-1][1] = stop
+	s.counts[n-1]++
+}
 
-		pos = rand.Int63n(EOF)
+// countFull returns true for segments holding bulkLen tokens.
+func countFull(bulkLen int) func([2]int64, int) bool {
+	return func(segment [2]int64, count int) bool {
+		return count >= bulkLen
 	}
+}
 
-	var sum int64
-	for _, a := range avgs {
-		sum += a
+// sizeFull returns true for segments of at least size bytes.
+func sizeFull(size int64) func([2]int64, int) bool {
+	return func(segment [2]int64, count int) bool {
+		return segment[1]-segment[0] >= size
 	}
+}
 
-	return int64(sum / int64(len(avgs))), nil
+// tokenSampler samples the sizes of tokens as they are read, as defined by the
+// options. The number of sizes it keeps does not depend on the number of tokens.
+type tokenSampler struct {
+	samples  int
+	strategy SamplingStrategy
+	random   *rand.Rand
+
+	// The number of tokens read
+	count int
+
+	// The sampled sizes. RandomSampling keeps the first token, then a reservoir
+	// of distinct random tokens. EvenSampling keeps the tokens whose index is a
+	// multiple of stride.
+	sizes  []int64
+	stride int
+
+	// The index of the next token entering the reservoir, and the weight used to
+	// skip the others, as in the algorithm L of Li.
+	next   int
+	weight float64
 }
 
-// nextStartOffset returns the offset of the byte before the next start token.
-func nextStartOffset(reader io.ReadSeeker, token string, offset int64) (int64, error) {
+// newTokenSampler returns a sampler for the options.
+func newTokenSampler(o ChunkOptions) *tokenSampler {
 
-	_, err := reader.Seek(offset, 0)
-	if err != nil {
-		return 0, err
+	if o.Samples <= 0 {
+		o.Samples = 10
+	}
+	source := o.Source
+	if source == nil {
+		source = rand.NewSource(o.Seed)
 	}
 
-	var last int64
-	decoder := xml.NewDecoder(reader)
-	for {
-		t, err := decoder.RawToken()
-		if err != nil {
-			return 0, err
-		}
-
-		// Break the loop as soon as the token is found.
-		elt, ok := t.(xml.StartElement)
-		if ok && elt.Name.Local == token {
-			return last + offset, nil
-		}
-
-		last = decoder.InputOffset()
+	return &tokenSampler{
+		samples:  o.Samples,
+		strategy: o.Strategy,
+		random:   rand.New(source),
+		stride:   1,
+		weight:   1,
 	}
 }
 
-// nextStopOffset returns the offset of the byte after the next stop token.
-func nextStopOffset(reader io.ReadSeeker, token string, offset int64) (int64, error) {
+// add samples the token. It returns errStopped once no more token can be sampled.
+func (s *tokenSampler) add(start, stop int64) error {
 
-	offset, err := reader.Seek(offset, 0)
-	if err != nil {
-		return 0, err
-	}
+	i := s.count
+	s.count++
+	size := stop - start
 
-	decoder := xml.NewDecoder(reader)
-	for {
-		t, err := decoder.RawToken()
-		if err != nil {
-			return 0, err
+	switch s.strategy {
+	case HeadSampling:
+		s.sizes = append(s.sizes, size)
+		if len(s.sizes) == s.samples {
+			return errStopped
 		}
 
-		// Break the loop as soon as the token is found.
-		elt, ok := t.(xml.EndElement)
-		if ok && elt.Name.Local == token {
-			return offset + decoder.InputOffset(), nil
+	case EvenSampling:
+		if i%s.stride != 0 {
+			return nil
 		}
-	}
-}
-
-// lastStopOffset returns the offset of the byte after the last stop token.
-// It processes a dichotomial research in the reader.
-func lastStopOffset(reader io.ReadSeeker, token string, start, stop int64) (int64, error) {
+		s.sizes = append(s.sizes, size)
 
-	half := start + (stop-start)/2
-	pos, err := nextStopOffset(reader, token, half)
-	if err != nil {
+		// Keep every other token once the buffer is full, so that the tokens
+		// are spaced by twice the stride.
+		if len(s.sizes) == 2*s.samples {
+			for j := 0; j < s.samples; j++ {
+				s.sizes[j] = s.sizes[2*j]
+			}
+			s.sizes = s.sizes[:s.samples]
+			s.stride *= 2
+		}
 
-		// Went to far
-		if err == io.EOF {
-			return lastStopOffset(reader, token, start, half)
+	default:
+		// Start with the first token, as the estimation would be meaningless if
+		// it is unique, then fill the reservoir with the following ones.
+		k := s.samples - 1
+		switch {
+		case i <= k:
+			s.sizes = append(s.sizes, size)
+			if i == k && k > 0 {
+				s.next = i
+				s.skip(k)
+			}
+		case i == s.next:
+			s.sizes[1+s.random.Intn(k)] = size
+			s.skip(k)
 		}
+	}
+
+	return nil
+}
 
-		return 0, err
+// skip defines the next token entering the reservoir of k tokens.
+func (s *tokenSampler) skip(k int) {
+	s.weight *= math.Exp(math.Log(1-s.random.Float64()) / float64(k))
+	n := math.Floor(math.Log(1-s.random.Float64())/math.Log(1-s.weight)) + 1
+	if n > math.MaxInt32 || math.IsNaN(n) {
+		n = math.MaxInt32
 	}
+	s.next += int(n)
+}
 
-	_, err = nextStopOffset(reader, token, pos)
-	if err != nil {
+// size returns the average size of the sampled tokens.
+func (s *tokenSampler) size() int64 {
 
-		// Last offset was the good one.
-		if err == io.EOF {
-			return pos, nil
-		}
+	if len(s.sizes) == 0 {
+		return 0
+	}
 
-		return 0, err
+	// Pick evenly spaced tokens among the kept ones.
+	if s.strategy == EvenSampling {
+		var sum int64
+		for i := 0; i < s.samples; i++ {
+			sum += s.sizes[len(s.sizes)*i/s.samples]
+		}
+		return sum / int64(s.samples)
 	}
 
-	// position was too short
-	return lastStopOffset(reader, token, half, stop)
+	var sum int64
+	for _, size := range s.sizes {
+		sum += size
+	}
+	return sum / int64(len(s.sizes))
 }
@@ -0,0 +1,229 @@
+package xmlx
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// chunkAllSeek is the former implementation of ChunkAll, kept to be benchmarked
+// against it. It estimates the size of the tokens by decoding a few of them, then
+// jumps from segment to segment, decoding from arbitrary positions.
+func chunkAllSeek(ctx context.Context, reader io.ReadSeeker, token string, bulkLen int, o ChunkOptions) ([][2]int64, error) {
+	var segments [][2]int64
+	var start, stop int64
+
+	size, err := guessTokenSize(ctx, reader, token, o)
+	if err != nil {
+		return nil, err
+	}
+	size *= int64(bulkLen)
+
+	EOF, err := reader.Seek(0, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate segments until the end of the file.
+	for err != io.EOF {
+
+		start, err = nextStartOffset(ctx, reader, token, stop)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		// Find next sto
+		jump := start + size
+		stop, err = nextStopOffset(ctx, reader, token, jump)
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			stop, err = lastStopOffset(ctx, reader, token, start, EOF)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		segment := [2]int64{start, stop}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+// guessTokenSize returns the size of one token found by the parser. If the parser could
+// not find the expected token, this function returns an error. The size is calculed as
+// the average size of the tokens sampled as defined by the options.
+func guessTokenSize(ctx context.Context, reader io.ReadSeeker, token string, o ChunkOptions) (int64, error) {
+
+	var avgs []int64
+
+	if o.Samples <= 0 {
+		o.Samples = 10
+	}
+	source := o.Source
+	if source == nil {
+		source = rand.NewSource(o.Seed)
+	}
+	random := rand.New(source)
+
+	// Get the len of the reader.
+	EOF, err := reader.Seek(0, 2)
+	if err != nil {
+		return 0, err
+	}
+
+	// Calculate an average size based on the sampled tokens.
+	// Start at position 0 to quickly find the node if it is unique.
+	var pos int64
+	for i := 0; i < o.Samples; i++ {
+
+		if i > 0 {
+			switch o.Strategy {
+			case EvenSampling:
+				pos = EOF / int64(o.Samples) * int64(i)
+			case RandomSampling:
+				pos = random.Int63n(EOF)
+			}
+		}
+
+		start, err := nextStartOffset(ctx, reader, token, pos)
+		if err != nil {
+
+			// This condition ensures the token exists: if the encountered error is an io.EOF
+			// when the position is still 0, it means that the reader could not find any token
+			// matching the parsers token.
+			if i == 0 && err == io.EOF {
+				return 0, fmt.Errorf("cannot find token \"%s\" in reader", token)
+			}
+
+			// Other positions may fall after the last token, or within some markup.
+			if i == 0 || ctx.Err() != nil {
+				return 0, err
+			}
+			if o.Strategy == HeadSampling {
+				break
+			}
+			continue
+		}
+
+		stop, err := nextStopOffset(ctx, reader, token, start)
+		if err != nil {
+			if i == 0 || ctx.Err() != nil {
+				return 0, err
+			}
+			if o.Strategy == HeadSampling {
+				break
+			}
+			continue
+		}
+
+		avgs = append(avgs, stop-start)
+		pos = stop
+	}
+
+	var sum int64
+	for _, a := range avgs {
+		sum += a
+	}
+
+	return int64(sum / int64(len(avgs))), nil
+}
+
+// nextStartOffset returns the offset of the byte before the next start token.
+func nextStartOffset(ctx context.Context, reader io.ReadSeeker, token string, offset int64) (int64, error) {
+
+	_, err := reader.Seek(offset, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var last int64
+	decoder := xml.NewDecoder(reader)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+		}
+
+		t, err := decoder.RawToken()
+		if err != nil {
+			return 0, err
+		}
+
+		// Break the loop as soon as the token is found.
+		elt, ok := t.(xml.StartElement)
+		if ok && elt.Name.Local == token {
+			return last + offset, nil
+		}
+
+		last = decoder.InputOffset()
+	}
+}
+
+// nextStopOffset returns the offset of the byte after the next stop token.
+func nextStopOffset(ctx context.Context, reader io.ReadSeeker, token string, offset int64) (int64, error) {
+
+	offset, err := reader.Seek(offset, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	decoder := xml.NewDecoder(reader)
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+		}
+
+		t, err := decoder.RawToken()
+		if err != nil {
+			return 0, err
+		}
+
+		// Break the loop as soon as the token is found.
+		elt, ok := t.(xml.EndElement)
+		if ok && elt.Name.Local == token {
+			return offset + decoder.InputOffset(), nil
+		}
+	}
+}
+
+// lastStopOffset returns the offset of the byte after the last stop token.
+// It processes a dichotomial research in the reader.
+func lastStopOffset(ctx context.Context, reader io.ReadSeeker, token string, start, stop int64) (int64, error) {
+
+	half := start + (stop-start)/2
+	pos, err := nextStopOffset(ctx, reader, token, half)
+	if err != nil {
+
+		// Went to far
+		if err == io.EOF {
+			return lastStopOffset(ctx, reader, token, start, half)
+		}
+
+		return 0, err
+	}
+
+	_, err = nextStopOffset(ctx, reader, token, pos)
+	if err != nil {
+
+		// Last offset was the good one.
+		if err == io.EOF {
+			return pos, nil
+		}
+
+		return 0, err
+	}
+
+	// position was too short
+	return lastStopOffset(ctx, reader, token, half, stop)
+}
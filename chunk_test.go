@@ -1,9 +1,14 @@
 package xmlx
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_ChunkAll(t *testing.T) {
@@ -277,3 +282,299 @@ func Test_Chunk(t *testing.T) {
 		}
 	}
 }
+
+// cancelReader cancels a context once a number of bytes has been read.
+type cancelReader struct {
+	io.ReadSeeker
+	read   int
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.read += n
+	if r.read >= r.limit {
+		r.cancel()
+	}
+	return n, err
+}
+
+func Test_ChunkAllContext(t *testing.T) {
+
+	input := parallelInput(2000)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ChunkAllContext(ctx, strings.NewReader(input), "song", 10)
+	if err != context.Canceled {
+		t.Logf("having error %v", err)
+		t.Fail()
+	}
+	_, err = ChunkContext(ctx, strings.NewReader(input), "song", 0)
+	if err != context.Canceled {
+		t.Logf("having error %v", err)
+		t.Fail()
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	_, err = ChunkAllContext(ctx, strings.NewReader(input), "song", 10)
+	if err != context.DeadlineExceeded {
+		t.Logf("having error %v", err)
+		t.Fail()
+	}
+
+	// Cancel the context in the middle of the scan.
+	ctx, cancel = context.WithCancel(context.Background())
+	r := &cancelReader{ReadSeeker: strings.NewReader(input), limit: len(input) / 2, cancel: cancel}
+	segments, err := ChunkAllContext(ctx, r, "song", 10)
+	if err != context.Canceled || segments != nil {
+		t.Logf("having %d segments and error %v", len(segments), err)
+		t.Fail()
+	}
+
+	// The result is unchanged with a context that is never done.
+	having, err := ChunkAllContext(context.Background(), strings.NewReader(input), "song", 10)
+	if err != nil || len(having) == 0 {
+		t.Logf("having %d segments and error %v", len(having), err)
+		t.Fail()
+	}
+}
+
+func Test_ChunkAllWith(t *testing.T) {
+
+	// Records have heterogeneous sizes.
+	var b strings.Builder
+	b.WriteString("<music><songs>")
+	for i := 1; i <= 300; i++ {
+		fmt.Fprintf(&b, `<song number="%d"><name>%s</name></song>`, i, strings.Repeat("la ", i%17*i%5))
+	}
+	b.WriteString("</songs></music>")
+	input := b.String()
+
+	for i, o := range []ChunkOptions{
+		{},
+		{Seed: 42, Samples: 3},
+		{Source: rand.NewSource(7)},
+		{Strategy: EvenSampling, Samples: 20},
+		{Strategy: HeadSampling, Samples: 500},
+	} {
+		first, err := ChunkAllWith(strings.NewReader(input), "song", 10, o)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		if o.Source != nil {
+			o.Source = rand.NewSource(7)
+		}
+		second, err := ChunkAllWith(strings.NewReader(input), "song", 10, o)
+		if err != nil || !reflect.DeepEqual(first, second) {
+			t.Logf("failed case %d: segments differ between runs (%v)", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		var total int
+		for j, s := range first {
+			count := strings.Count(input[s[0]:s[1]], "<song ")
+			if j < len(first)-1 && count < 10 {
+				t.Logf("failed case %d: segment %d has %d records", i+1, j, count)
+				t.Fail()
+			}
+			total += count
+		}
+		if total != 300 {
+			t.Logf("failed case %d: having %d records", i+1, total)
+			t.Fail()
+		}
+	}
+
+	// The seed is the default source.
+	having, _ := ChunkAllWith(strings.NewReader(input), "song", 10, ChunkOptions{Seed: 7})
+	expected, _ := ChunkAllWith(strings.NewReader(input), "song", 10, ChunkOptions{Source: rand.NewSource(7)})
+	if !reflect.DeepEqual(having, expected) {
+		t.Log("expected the same segments for the same seed")
+		t.Fail()
+	}
+
+	_, err := ChunkAllWith(strings.NewReader(input), "album", 10, ChunkOptions{Strategy: EvenSampling})
+	if err == nil {
+		t.Log("expected an error for a missing token")
+		t.Fail()
+	}
+}
+
+func Test_tokenSampler(t *testing.T) {
+
+	// Sizes are evenly distributed between 0 and 199, averaging 99.
+	for i, o := range []ChunkOptions{
+		{Samples: 500},
+		{Samples: 500, Strategy: EvenSampling},
+	} {
+		s := newTokenSampler(o)
+		for j := 0; j < 100000; j++ {
+			s.add(0, int64(j%200))
+		}
+
+		if len(s.sizes) > 2*o.Samples {
+			t.Logf("failed case %d: having %d sizes", i+1, len(s.sizes))
+			t.Fail()
+		}
+		if size := s.size(); size < 89 || size > 109 {
+			t.Logf("failed case %d: having %d", i+1, size)
+			t.Fail()
+		}
+	}
+
+	tokens := [][2]int64{{0, 10}, {10, 30}, {30, 60}, {60, 100}}
+	for i, c := range []struct {
+		options ChunkOptions
+		min     int64
+		max     int64
+	}{
+		{options: ChunkOptions{Strategy: HeadSampling, Samples: 3}, min: 20, max: 20},
+		{options: ChunkOptions{Strategy: HeadSampling}, min: 25, max: 25},
+		{options: ChunkOptions{Strategy: EvenSampling, Samples: 2}, min: 20, max: 20},
+		{options: ChunkOptions{Samples: 1}, min: 10, max: 10},
+		{options: ChunkOptions{Samples: 1000, Seed: 3}, min: 24, max: 26},
+	} {
+		s := newTokenSampler(c.options)
+		for _, token := range tokens {
+			if s.add(token[0], token[1]) != nil {
+				break
+			}
+		}
+		if size := s.size(); size < c.min || size > c.max {
+			t.Logf("failed case %d: having %d, expected [%d, %d]", i+1, size, c.min, c.max)
+			t.Fail()
+		}
+	}
+}
+
+func Test_ChunkAllExact(t *testing.T) {
+
+	input := `<music><songs><song><name>One</name></song><song/>` +
+		`</songs><b><songs><song><song>nested</song></song></songs></b>` +
+		`<song number="4">Four</song><song>Five</song></music>`
+
+	for i, c := range []struct {
+		bulkLen int
+		out     []string
+		counts  []int
+	}{
+		{
+			bulkLen: 2,
+			out: []string{
+				`<song><name>One</name></song><song/>`,
+				`<song><song>nested</song></song></songs></b><song number="4">Four</song>`,
+				`<song>Five</song>`,
+			},
+			counts: []int{2, 2, 1},
+		},
+		{
+			bulkLen: 10,
+			out:     []string{input[len(`<music><songs>`) : len(input)-len(`</music>`)]},
+			counts:  []int{5},
+		},
+		{
+			bulkLen: 1,
+			out:     []string{`<song><name>One</name></song>`, `<song/>`, `<song><song>nested</song></song>`, `<song number="4">Four</song>`, `<song>Five</song>`},
+			counts:  []int{1, 1, 1, 1, 1},
+		},
+	} {
+		segments, counts, err := ChunkAllExact(strings.NewReader(input), "song", c.bulkLen)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		var out []string
+		for _, s := range segments {
+			out = append(out, input[s[0]:s[1]])
+		}
+		if !reflect.DeepEqual(out, c.out) || !reflect.DeepEqual(counts, c.counts) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having: %q %v", out, counts)
+			t.Logf("expected: %q %v", c.out, c.counts)
+			t.Fail()
+		}
+	}
+
+	for _, in := range []string{
+		`<music><album/></music>`,
+		`<music><song>One</song><song>Two`,
+		`<music><song>One</song><song <`,
+	} {
+		_, _, err := ChunkAllExact(strings.NewReader(in), "song", 1)
+		if err == nil {
+			t.Logf("expected an error for %q", in)
+			t.Fail()
+		}
+	}
+
+	_, _, err := ChunkAllExact(strings.NewReader(input), "song", 0)
+	if err == nil {
+		t.Log("expected an error for an empty bulk length")
+		t.Fail()
+	}
+}
+
+func Test_ChunkAllBytes(t *testing.T) {
+
+	input := `<music><song>1</song><song>22</song><song>` + strings.Repeat("3", 40) + `</song>` +
+		`<b/><song>4</song><song>5</song></music>`
+
+	for i, c := range []struct {
+		size   int64
+		out    []string
+		counts []int
+	}{
+		{
+			size: 20,
+			out: []string{
+				`<song>1</song><song>22</song>`,
+				`<song>` + strings.Repeat("3", 40) + `</song>`,
+				`<song>4</song><song>5</song>`,
+			},
+			counts: []int{2, 1, 2},
+		},
+		{
+			size:   1,
+			out:    []string{`<song>1</song>`, `<song>22</song>`, `<song>` + strings.Repeat("3", 40) + `</song>`, `<song>4</song>`, `<song>5</song>`},
+			counts: []int{1, 1, 1, 1, 1},
+		},
+		{
+			size:   1 << 20,
+			out:    []string{input[len(`<music>`) : len(input)-len(`</music>`)]},
+			counts: []int{5},
+		},
+	} {
+		segments, counts, err := ChunkAllBytes(strings.NewReader(input), "song", c.size)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		var out []string
+		for _, s := range segments {
+			out = append(out, input[s[0]:s[1]])
+		}
+		if !reflect.DeepEqual(out, c.out) || !reflect.DeepEqual(counts, c.counts) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having: %q %v", out, counts)
+			t.Logf("expected: %q %v", c.out, c.counts)
+			t.Fail()
+		}
+	}
+
+	_, _, err := ChunkAllBytes(strings.NewReader(input), "song", 0)
+	if err == nil {
+		t.Log("expected an error for an empty size")
+		t.Fail()
+	}
+}
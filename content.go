@@ -0,0 +1,191 @@
+package xmlx
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// ContentKind is the kind of a content segment.
+type ContentKind int
+
+// Kinds of content segments. Since encoding/xml reports CDATA sections as text,
+// decoded CDATA sections are TextContent segments. CDATAContent segments are
+// written as CDATA sections.
+const (
+	TextContent ContentKind = iota
+	ElementContent
+	CommentContent
+	ProcInstContent
+	CDATAContent
+)
+
+// text returns true for the segments holding text of the node.
+func (k ContentKind) text() bool {
+	return k == TextContent || k == CDATAContent
+}
+
+// Content is a segment of the content of a node.
+type Content struct {
+
+	// The kind of the segment
+	Kind ContentKind
+
+	// The text of the segment, the comment or the instruction of a processing
+	// instruction. It is empty for elements.
+	Data string
+
+	// The target of a processing instruction
+	Target string
+}
+
+// setContent sets the content of the node from its decoded segments. Data receives
+// the text of the node, and Content is only kept when Data and Nodes cannot describe
+// the node: when text follows subnodes or is split in several segments, or when
+// the node contains CDATA sections, comments or processing instructions.
+func (n *Node) setContent(content []Content) {
+
+	n.Data = contentData(content)
+
+	var significant, elements int
+	keep := false
+	for _, c := range content {
+		switch c.Kind {
+		case TextContent:
+			if len(strings.TrimSpace(c.Data)) != 0 {
+				significant++
+				keep = keep || elements != 0
+			}
+		case ElementContent:
+			elements++
+		default:
+			keep = true
+		}
+	}
+
+	if keep || significant > 1 {
+		n.Content = content
+	}
+}
+
+// contentData returns the Data of a node having the given content: its only
+// significant text segment, or the concatenation of its text segments.
+func contentData(content []Content) string {
+
+	var texts []string
+	var data string
+	var significant int
+	for _, c := range content {
+		if !c.Kind.text() {
+			continue
+		}
+		texts = append(texts, c.Data)
+		if len(strings.TrimSpace(c.Data)) != 0 || c.Kind == CDATAContent {
+			data = c.Data
+			significant++
+		}
+	}
+
+	if significant > 1 {
+		return strings.Join(texts, "")
+	}
+	return data
+}
+
+// removeNode removes the ith subnode of the node, along with its segment in Content.
+func (n *Node) removeNode(i int) {
+
+	n.Nodes = append(n.Nodes[:i], n.Nodes[i+1:]...)
+	if n.Content == nil {
+		return
+	}
+
+	var elements int
+	for j, c := range n.Content {
+		if c.Kind != ElementContent {
+			continue
+		}
+		if elements == i {
+			n.Content = append(n.Content[:j], n.Content[j+1:]...)
+			return
+		}
+		elements++
+	}
+}
+
+// appendNode appends a subnode to the node, along with its segment in Content.
+func (n *Node) appendNode(node Node) {
+	n.Nodes = append(n.Nodes, node)
+	if n.Content != nil {
+		n.Content = append(n.Content, Content{Kind: ElementContent})
+	}
+}
+
+// marshalContent encodes the content segments of the node. The element segments
+// are taken in order from Nodes. When Data no longer matches the text segments,
+// it replaces them at the position of the first one.
+func (n Node) marshalContent(e *xml.Encoder, s scope) error {
+
+	content := n.Content
+	if contentData(content) != n.Data {
+		content = nil
+		replaced := false
+		for _, c := range n.Content {
+			if !c.Kind.text() {
+				content = append(content, c)
+				continue
+			}
+			if !replaced && len(n.Data) != 0 {
+				content = append(content, Content{Kind: TextContent, Data: n.Data})
+			}
+			replaced = true
+		}
+		if !replaced && len(n.Data) != 0 {
+			content = append([]Content{{Kind: TextContent, Data: n.Data}}, content...)
+		}
+	}
+
+	var elements int
+	for _, c := range content {
+		var err error
+		switch c.Kind {
+		case TextContent:
+			err = e.EncodeToken(xml.CharData(c.Data))
+		case CDATAContent:
+			err = encodeCDATA(e, c.Data)
+		case CommentContent:
+			err = e.EncodeToken(xml.Comment(c.Data))
+		case ProcInstContent:
+			err = e.EncodeToken(xml.ProcInst{Target: c.Target, Inst: []byte(c.Data)})
+		case ElementContent:
+			if elements < len(n.Nodes) {
+				err = n.Nodes[elements].marshal(e, s)
+				elements++
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write the subnodes that do not have a segment.
+	for _, node := range n.Nodes[elements:] {
+		err := node.marshal(e, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeCDATA writes the text as a CDATA section, or as escaped text when it
+// cannot be written as such by the encoder.
+func encodeCDATA(e *xml.Encoder, text string) error {
+	if !strings.Contains(text, "]]>") {
+		err := e.EncodeToken(xml.Directive("[CDATA[" + text + "]]"))
+		if err == nil {
+			return nil
+		}
+	}
+	return e.EncodeToken(xml.CharData(text))
+}
@@ -0,0 +1,147 @@
+package xmlx
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// JoinMode defines how a column selecting several values is written.
+type JoinMode int
+
+// Join modes. JoinAll joins the values with the separator of the column.
+const (
+	JoinFirst JoinMode = iota
+	JoinLast
+	JoinAll
+)
+
+// Column defines a column of a CSV output.
+type Column struct {
+
+	// The header of the column
+	Header string
+
+	// The query expression selecting the values of the column, relative to
+	// each written node. See Node.Query for the syntax.
+	Path string
+
+	// The value written when the path selects nothing
+	Default string
+
+	// The way several values are written
+	Join JoinMode
+
+	// The separator used by JoinAll, "|" by default
+	Separator string
+}
+
+// CSVWriter writes nodes as CSV rows, one row per node.
+type CSVWriter struct {
+	w       *csv.Writer
+	columns []Column
+	paths   []*Path
+	started bool
+}
+
+// NewCSVWriter returns a new writer that writes to w. An error is returned if
+// the path of a column cannot be compiled.
+func NewCSVWriter(w io.Writer, columns []Column) (*CSVWriter, error) {
+
+	paths := make([]*Path, len(columns))
+	for i, c := range columns {
+		p, err := CompilePath(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = p
+	}
+
+	return &CSVWriter{w: csv.NewWriter(w), columns: columns, paths: paths}, nil
+}
+
+// SetComma sets the field delimiter, which is a comma by default.
+func (w *CSVWriter) SetComma(r rune) {
+	w.w.Comma = r
+}
+
+// Write writes the row of the node. The header is written before the first row,
+// unless every column has an empty header. Rows are buffered: call Flush once done.
+func (w *CSVWriter) Write(n Node) error {
+
+	err := w.start()
+	if err != nil {
+		return err
+	}
+
+	row := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		var values []string
+		for _, node := range w.paths[i].Select(n) {
+			values = append(values, stringValue(&node))
+		}
+
+		switch {
+		case len(values) == 0:
+			row[i] = c.Default
+		case c.Join == JoinLast:
+			row[i] = values[len(values)-1]
+		case c.Join == JoinAll:
+			sep := c.Separator
+			if sep == "" {
+				sep = "|"
+			}
+			row[i] = strings.Join(values, sep)
+		default:
+			row[i] = values[0]
+		}
+	}
+
+	return w.w.Write(row)
+}
+
+// WriteAll writes the rows of the nodes and flushes the writer.
+func (w *CSVWriter) WriteAll(nodes []Node) error {
+
+	for _, n := range nodes {
+		err := w.Write(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// Flush writes the buffered rows, and the header if no row was written.
+func (w *CSVWriter) Flush() error {
+
+	err := w.start()
+	if err != nil {
+		return err
+	}
+
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// start writes the header if it has not been written yet.
+func (w *CSVWriter) start() error {
+
+	if w.started {
+		return nil
+	}
+	w.started = true
+
+	var header []string
+	var named bool
+	for _, c := range w.columns {
+		header = append(header, c.Header)
+		named = named || c.Header != ""
+	}
+	if !named {
+		return nil
+	}
+
+	return w.w.Write(header)
+}
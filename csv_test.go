@@ -0,0 +1,103 @@
+package xmlx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+func Test_CSVWriter(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(queryInput), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	albums, err := node.Query("album")
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	for i, c := range []struct {
+		columns []Column
+		comma   rune
+		out     string
+	}{
+		{
+			columns: []Column{
+				{Header: "album", Path: "/album/@name"},
+				{Header: "type", Path: "/album/@type"},
+				{Header: "first", Path: "songs/song/name"},
+				{Header: "last", Path: "songs/song/name", Join: JoinLast},
+				{Header: "singles", Path: "songs/song[@single='true']/name", Join: JoinAll, Default: "none"},
+				{Header: "numbers", Path: "songs/song/@number", Join: JoinAll, Separator: "+"},
+			},
+			out: `album,type,first,last,singles,numbers
+Black Album,studio,Enter Sandman,The Unforgiven,The Unforgiven,1+2+3
+Load,studio,Ain't My Bitch,2 X 4,2 X 4,1+2
+S&M,live,,,none,
+`,
+		},
+		{
+			columns: []Column{
+				{Path: "@name"},
+				{Path: "m:meta/band", Default: "unknown"},
+			},
+			comma: ';',
+			out: `Black Album;Metallica
+Load;unknown
+S&M;unknown
+`,
+		},
+	} {
+		var b bytes.Buffer
+		w, err := NewCSVWriter(&b, c.columns)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+		if c.comma != 0 {
+			w.SetComma(c.comma)
+		}
+
+		err = w.WriteAll(albums)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		if b.String() != c.out {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having: %q", b.String())
+			t.Logf("expected: %q", c.out)
+			t.Fail()
+		}
+	}
+}
+
+func Test_CSVWriterHeaderOnly(t *testing.T) {
+
+	var b bytes.Buffer
+	w, err := NewCSVWriter(&b, []Column{{Header: "name", Path: "@name"}, {Header: "type", Path: "@type"}})
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	err = w.Flush()
+	if err != nil || b.String() != "name,type\n" {
+		t.Logf("having: %q %v", b.String(), err)
+		t.Fail()
+	}
+
+	_, err = NewCSVWriter(&b, []Column{{Path: "album["}})
+	if err == nil {
+		t.Log("expected an error")
+		t.Fail()
+	}
+}
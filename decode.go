@@ -0,0 +1,216 @@
+package xmlx
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Decode fills the value pointed to by v from the node. Struct fields are
+// filled from the nodes selected by the query expression of their xmlx tag,
+// relative to the node, as described in Node.Query:
+//
+//	type Song struct {
+//		Number int      `xmlx:"@number"`
+//		Name   string   `xmlx:"name"`
+//		Tags   []string `xmlx:"tags/tag"`
+//	}
+//
+// Slices receive every selected node, other fields the first one. Fields whose
+// path selects nothing are left unchanged. Strings, byte slices, numbers and
+// booleans are parsed from the text of the selected node, as well as the types
+// implementing encoding.TextUnmarshaler, such as time.Time. Surrounding spaces
+// are ignored, except for strings and byte slices. Nested structs are decoded
+// with the selected node as context, and Node fields receive the selected node
+// itself.
+//
+// Fields without tag, or tagged "-", are ignored, except for embedded structs
+// whose fields are decoded as if they belonged to the outer struct.
+func (n Node) Decode(v interface{}) error {
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("invalid value %T: expected a non-nil pointer", v)
+	}
+
+	return decodeValue(n, rv.Elem())
+}
+
+var (
+	nodeType            = reflect.TypeOf(Node{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// decodeValue fills v from the node.
+func decodeValue(n Node, v reflect.Value) error {
+
+	if v.Type() == nodeType {
+		v.Set(reflect.ValueOf(n.clone()))
+		return nil
+	}
+
+	if v.Kind() != reflect.Ptr && reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(strings.TrimSpace(stringValue(&n))))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(n, v.Elem())
+
+	case reflect.Struct:
+		fields, err := decodeFields(v.Type())
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			err := f.decode(n, v.FieldByIndex(f.index))
+			if err != nil {
+				return fmt.Errorf("invalid field %s: %s", f.name, err)
+			}
+		}
+		return nil
+	}
+
+	return decodeText(stringValue(&n), v)
+}
+
+// decodeText parses the text into v.
+func decodeText(text string, v reflect.Value) error {
+
+	s := strings.TrimSpace(text)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		v.SetBytes([]byte(text))
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		v.Set(reflect.ValueOf(text))
+
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// decodeField is a struct field filled by Decode.
+type decodeField struct {
+	name  string
+	index []int
+	path  *Path
+}
+
+// decode fills the field from the nodes its path selects in n.
+func (f decodeField) decode(n Node, v reflect.Value) error {
+
+	nodes := f.path.Select(n)
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	for v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.Slice {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+		return decodeValue(nodes[0], v)
+	}
+
+	s := reflect.MakeSlice(v.Type(), len(nodes), len(nodes))
+	for i, node := range nodes {
+		err := decodeValue(node, s.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(s)
+
+	return nil
+}
+
+// fieldCache holds the fields of the struct types already decoded.
+var fieldCache sync.Map
+
+// decodeFields returns the fields of the struct type filled by Decode.
+func decodeFields(t reflect.Type) ([]decodeField, error) {
+
+	if fields, ok := fieldCache.Load(t); ok {
+		return fields.([]decodeField), nil
+	}
+
+	var fields []decodeField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("xmlx")
+
+		if !tagged && sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			embedded, err := decodeFields(sf.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range embedded {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+
+		if !tagged || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+
+		p, err := CompilePath(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag of field %s: %s", sf.Name, err)
+		}
+		fields = append(fields, decodeField{name: sf.Name, index: []int{i}, path: p})
+	}
+
+	fieldCache.Store(t, fields)
+	return fields, nil
+}
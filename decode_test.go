@@ -0,0 +1,164 @@
+package xmlx
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type decodedSong struct {
+	Number uint8  `xmlx:"@number"`
+	Name   string `xmlx:"name"`
+	Single *bool  `xmlx:"@single"`
+}
+
+type decodedMeta struct {
+	Band string `xmlx:"band"`
+	Year int    `xmlx:"year"`
+}
+
+type decodedAlbum struct {
+	decodedMeta
+	Name    string        `xmlx:"@name"`
+	Kind    string        `xmlx:"@type"`
+	Label   []byte        `xmlx:"@type"`
+	Meta    *decodedMeta  `xmlx:"m:meta"`
+	Songs   []decodedSong `xmlx:"songs/song"`
+	Titles  []string      `xmlx:"songs/song/name"`
+	Count   float64       `xmlx:"missing"`
+	Raw     Node          `xmlx:"songs/song[last()]"`
+	Ignored string
+	Skipped string `xmlx:"-"`
+}
+
+func Test_NodeDecode(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(queryInput), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	var albums []decodedAlbum
+	err = node.Decode(&struct {
+		Albums *[]decodedAlbum `xmlx:"album"`
+	}{Albums: &albums})
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	if len(albums) != 3 {
+		t.Logf("having %d albums", len(albums))
+		t.FailNow()
+	}
+
+	yes := true
+	expected := decodedAlbum{
+		Name:  "Black Album",
+		Kind:  "studio",
+		Label: []byte("studio"),
+		Meta:  &decodedMeta{Band: "Metallica", Year: 1991},
+		Count: 1.5,
+		Songs: []decodedSong{
+			{Number: 1, Name: "Enter Sandman"},
+			{Number: 2, Name: "Sad but True"},
+			{Number: 3, Name: "The Unforgiven", Single: &yes},
+		},
+		Titles:  []string{"Enter Sandman", "Sad but True", "The Unforgiven"},
+		Ignored: "kept",
+		Skipped: "kept",
+	}
+
+	album, _, err := node.QueryOne("album")
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	having := decodedAlbum{Count: 1.5, Ignored: "kept", Skipped: "kept"}
+	err = album.Decode(&having)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	if having.Raw.Name != "song" || having.Raw.Attrs["number"] != "3" {
+		t.Logf("having raw node: %v", having.Raw)
+		t.Fail()
+	}
+	having.Raw = Node{}
+
+	if !reflect.DeepEqual(having, expected) {
+		t.Logf("having: %+v", having)
+		t.Logf("expected: %+v", expected)
+		t.Fail()
+	}
+
+	if albums[1].Name != "Load" || len(albums[1].Songs) != 2 || albums[1].Meta != nil || albums[2].Songs != nil {
+		t.Logf("having: %+v", albums)
+		t.Fail()
+	}
+}
+
+func Test_NodeDecodeEmbedded(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(`<meta><band>Metallica</band><year>1991</year><at> 2019-03-01T10:00:00Z </at></meta>`), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	var having struct {
+		decodedMeta
+		At   time.Time   `xmlx:"at"`
+		Any  interface{} `xmlx:"band"`
+		Type Type        `xmlx:"missing"`
+	}
+	err = node.Decode(&having)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	if having.Band != "Metallica" || having.Year != 1991 || having.Any != "Metallica" ||
+		!having.At.Equal(time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Logf("having: %+v", having)
+		t.Fail()
+	}
+}
+
+func Test_NodeDecodeInvalid(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(`<song number="x"><name>One</name></song>`), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	var song decodedSong
+	var invalid struct {
+		Name string `xmlx:"name["`
+	}
+	var unsupported struct {
+		Names map[string]string `xmlx:"name"`
+	}
+
+	for i, v := range []interface{}{
+		song,
+		(*decodedSong)(nil),
+		&song,
+		&invalid,
+		&unsupported,
+	} {
+		err := node.Decode(v)
+		if err == nil {
+			t.Logf("failed case %d: expected an error", i+1)
+			t.Fail()
+		}
+	}
+}
@@ -9,4 +9,20 @@
 //
 // The Split method returns an array of nodes having the same property as the parent,
 // splitted after a subnode name.
+//
+// Nodes keep the namespace of their elements and attributes, and can be marshalled
+// back into XML. The Query method selects subnodes with a subset of XPath, and
+// the Decode method fills structs from the paths found in their tags.
+//
+// JSONEncoder and JSONDecoder convert nodes from and to JSON, following one of
+// the usual conventions, and CSVWriter writes nodes as CSV rows.
+//
+// A Mapping, loaded from JSON or YAML, describes the records of a source and
+// their fields: a Mapper applies it to a file without any Go structure.
+//
+// RecordScanner decodes the records of a stream one at a time, in a single pass,
+// while ProcessParallel decodes the segments returned by ChunkAll concurrently.
+// An Index keeps the offsets of the records of a file, and can be saved to
+// segment it again without reading it. Resync finds where markup ends after
+// an arbitrary offset, so that Chunk can start anywhere in a file.
 package xmlx
@@ -0,0 +1,679 @@
+package xmlx
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	bin "encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// TokenOffsets reads the reader once and returns the start and stop offsets of
+// every token, as Chunk does. Tokens nested in a token are part of it, and are
+// not returned on their own.
+//
+// The markup is read without being decoded: comments, CDATA sections, processing
+// instructions, declarations and quoted attribute values are skipped as such, so
+// the names they contain are never mistaken for tokens.
+func TokenOffsets(reader io.Reader, token string) ([][2]int64, error) {
+	return TokenOffsetsContext(context.Background(), reader, token)
+}
+
+// TokenOffsetsContext works as TokenOffsets, and stops with the error of the
+// context as soon as it is done.
+func TokenOffsetsContext(ctx context.Context, reader io.Reader, token string) ([][2]int64, error) {
+
+	var offsets [][2]int64
+	err := scanTokens(ctx, reader, token, func(start, stop int64) error {
+		offsets = append(offsets, [2]int64{start, stop})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return offsets, nil
+}
+
+// errStopped is returned internally to stop a scan, or a worker after another
+// one failed.
+var errStopped = errors.New("stopped")
+
+// scanTokens reads the reader once, and calls fn with the start and stop offsets
+// of each token. Tokens nested in a token are part of it, and are not reported.
+func scanTokens(ctx context.Context, reader io.Reader, token string, fn func(start, stop int64) error) error {
+
+	l := &lexer{r: reader, buf: make([]byte, 0, 64*1024)}
+	var depth int
+	var mark int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		kind, name, start, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case startTag, emptyTag:
+			if depth > 0 {
+				if kind == startTag {
+					depth++
+				}
+				continue
+			}
+			if string(name) != token {
+				continue
+			}
+			if kind == startTag {
+				depth = 1
+				mark = start
+				continue
+			}
+			err := fn(start, l.offset())
+			if err != nil {
+				return err
+			}
+
+		case endTag:
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				err := fn(mark, l.offset())
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if depth > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+// tagKind is the kind of a tag read by the lexer.
+type tagKind int
+
+const (
+	otherTag tagKind = iota
+	startTag
+	endTag
+	emptyTag
+)
+
+// lexer reads the tags of an XML document, without decoding nor checking them.
+type lexer struct {
+	r    io.Reader
+	buf  []byte // the data read and not consumed yet starts at pos
+	pos  int
+	base int64 // the offset of buf[0] in the reader
+	err  error // the read error, returned once buf is consumed
+	name []byte
+}
+
+// offset returns the offset of the next byte in the reader.
+func (l *lexer) offset() int64 {
+	return l.base + int64(l.pos)
+}
+
+// fill reads more data into the buffer. It returns false once the reader is
+// exhausted.
+func (l *lexer) fill() bool {
+
+	if l.err != nil {
+		return false
+	}
+
+	if l.pos > 0 {
+		n := copy(l.buf[:cap(l.buf)], l.buf[l.pos:])
+		l.base += int64(l.pos)
+		l.buf, l.pos = l.buf[:n], 0
+	}
+	if len(l.buf) == cap(l.buf) {
+		buf := make([]byte, len(l.buf), 2*cap(l.buf))
+		copy(buf, l.buf)
+		l.buf = buf
+	}
+
+	n, err := l.r.Read(l.buf[len(l.buf):cap(l.buf)])
+	l.buf = l.buf[:len(l.buf)+n]
+	if err != nil {
+		l.err = err
+	}
+	return n > 0 || err == nil
+}
+
+// peek returns at least n bytes starting at the next byte, or less at the end
+// of the reader.
+func (l *lexer) peek(n int) []byte {
+	for len(l.buf)-l.pos < n && l.fill() {
+	}
+	return l.buf[l.pos:]
+}
+
+// readByte consumes and returns the next byte.
+func (l *lexer) readByte() (byte, bool) {
+	for l.pos >= len(l.buf) {
+		if !l.fill() {
+			return 0, false
+		}
+	}
+	c := l.buf[l.pos]
+	l.pos++
+	return c, true
+}
+
+// skipTo consumes the bytes preceding the next c.
+func (l *lexer) skipTo(c byte) bool {
+	for {
+		if i := bytes.IndexByte(l.buf[l.pos:], c); i >= 0 {
+			l.pos += i
+			return true
+		}
+		l.pos = len(l.buf)
+		if !l.fill() {
+			return false
+		}
+	}
+}
+
+// skipPast consumes the bytes up to the end of the next s.
+func (l *lexer) skipPast(s string) bool {
+	for {
+		if i := bytes.Index(l.buf[l.pos:], []byte(s)); i >= 0 {
+			l.pos += i + len(s)
+			return true
+		}
+		if keep := len(l.buf) - len(s) + 1; keep > l.pos {
+			l.pos = keep
+		}
+		if !l.fill() {
+			return false
+		}
+	}
+}
+
+// next consumes the next tag, and returns its kind, its local name and its
+// start offset. It returns io.EOF once the reader is exhausted outside of any
+// markup, and io.ErrUnexpectedEOF if it is exhausted within a tag.
+func (l *lexer) next() (tagKind, []byte, int64, error) {
+
+	if !l.skipTo('<') {
+		if l.err != nil && l.err != io.EOF {
+			return otherTag, nil, 0, l.err
+		}
+		return otherTag, nil, 0, io.EOF
+	}
+	start := l.offset()
+
+	var ok bool
+	kind := otherTag
+	p := l.peek(9)
+	switch {
+	case bytes.HasPrefix(p, []byte("<!--")):
+		l.pos += 4
+		ok = l.skipPast("-->")
+	case bytes.HasPrefix(p, []byte("<![CDATA[")):
+		l.pos += 9
+		ok = l.skipPast("]]>")
+	case bytes.HasPrefix(p, []byte("<?")):
+		l.pos += 2
+		ok = l.skipPast("?>")
+	case bytes.HasPrefix(p, []byte("<!")):
+		l.pos += 2
+		ok = l.skipDeclaration()
+	case bytes.HasPrefix(p, []byte("</")):
+		l.pos += 2
+		kind = endTag
+		ok = l.readName() && l.skipTo('>')
+		l.pos++
+	default:
+		l.pos++
+		ok = l.readName()
+		if ok && len(l.name) == 0 {
+			return otherTag, nil, 0, fmt.Errorf("invalid markup at offset %d", start)
+		}
+		if ok {
+			kind, ok = l.skipAttributes()
+		}
+	}
+
+	if !ok {
+		if l.err != nil && l.err != io.EOF {
+			return otherTag, nil, 0, l.err
+		}
+		return otherTag, nil, 0, io.ErrUnexpectedEOF
+	}
+
+	return kind, l.name, start, nil
+}
+
+// readName reads the name of a tag, and keeps its local part.
+func (l *lexer) readName() bool {
+
+	l.name = l.name[:0]
+	for {
+		c, ok := l.readByte()
+		if !ok {
+			return false
+		}
+		switch c {
+		case ' ', '\t', '\r', '\n', '/', '>':
+			l.pos--
+			return true
+		case ':':
+			l.name = l.name[:0]
+		default:
+			l.name = append(l.name, c)
+		}
+	}
+}
+
+// skipAttributes consumes the attributes of a start tag, up to its end. The kind
+// of the tag tells whether it is self-closing.
+func (l *lexer) skipAttributes() (tagKind, bool) {
+
+	var last byte
+	for {
+		c, ok := l.readByte()
+		if !ok {
+			return startTag, false
+		}
+		switch c {
+		case '"', '\'':
+			if !l.skipTo(c) {
+				return startTag, false
+			}
+			l.pos++
+		case '>':
+			if last == '/' {
+				return emptyTag, true
+			}
+			return startTag, true
+		}
+		last = c
+	}
+}
+
+// skipDeclaration consumes a declaration such as a DOCTYPE, along with its
+// internal subset, up to its end.
+func (l *lexer) skipDeclaration() bool {
+
+	var depth int
+	for {
+		c, ok := l.readByte()
+		if !ok {
+			return false
+		}
+		switch c {
+		case '"', '\'':
+			if !l.skipTo(c) {
+				return false
+			}
+			l.pos++
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '<':
+			if p := l.peek(3); bytes.HasPrefix(p, []byte("!--")) {
+				if !l.skipPast("-->") {
+					return false
+				}
+			}
+		case '>':
+			if depth <= 0 {
+				return true
+			}
+		}
+	}
+}
+
+// Index holds the offsets of the tokens of a source, along with a description of
+// the source, so that it can be saved and reloaded instead of reading the source
+// again.
+type Index struct {
+
+	// The name of the indexed tokens
+	Token string
+
+	// The size of the source
+	Size int64
+
+	// The modification time of the source, if it is a file
+	ModTime time.Time
+
+	// The CRC-32 checksum of the source, with the Castagnoli polynomial
+	Checksum uint32
+
+	// The start and stop offsets of the tokens, as returned by TokenOffsets
+	Offsets [][2]int64
+}
+
+// indexMagic starts the binary representation of an index.
+const indexMagic = "xmlx-index/1\n"
+
+// crcTable is the table of the checksums of the indexed sources.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// BuildIndex reads the reader once and returns the index of its tokens.
+func BuildIndex(reader io.Reader, token string) (*Index, error) {
+	return BuildIndexContext(context.Background(), reader, token)
+}
+
+// BuildIndexContext works as BuildIndex, and stops with the error of the context
+// as soon as it is done.
+func BuildIndexContext(ctx context.Context, reader io.Reader, token string) (*Index, error) {
+
+	hash := crc32.New(crcTable)
+	counter := &countWriter{w: hash}
+	offsets, err := TokenOffsetsContext(ctx, io.TeeReader(reader, counter), token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Index{Token: token, Size: counter.n, Checksum: hash.Sum32(), Offsets: offsets}, nil
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// OpenIndex returns the index of the tokens of the named file. The index is read
+// from the index file when it exists and matches the file, and is built and saved
+// into the index file otherwise.
+func OpenIndex(name, token, index string) (*Index, error) {
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	x, err := readIndexFile(index)
+	if err == nil && x.Token == token && x.Matches(info) {
+		return x, nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	x, err = BuildIndex(f, token)
+	if err != nil {
+		return nil, err
+	}
+	x.ModTime = info.ModTime()
+
+	err = x.save(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// readIndexFile reads the named index file.
+func readIndexFile(name string) (*Index, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadIndex(bufio.NewReader(f))
+}
+
+// save writes the index into the named file.
+func (x *Index) save(name string) error {
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	_, err = x.WriteTo(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Matches returns true if the file has the size and modification time of the
+// indexed source.
+func (x *Index) Matches(info os.FileInfo) bool {
+	return info.Size() == x.Size && info.ModTime().Equal(x.ModTime)
+}
+
+// Verify reads the reader and returns an error if it does not have the size
+// and checksum of the indexed source.
+func (x *Index) Verify(reader io.Reader) error {
+
+	hash := crc32.New(crcTable)
+	n, err := io.Copy(hash, reader)
+	if err != nil {
+		return err
+	}
+
+	if n != x.Size || hash.Sum32() != x.Checksum {
+		return fmt.Errorf("index does not match the source")
+	}
+
+	return nil
+}
+
+// Len returns the number of tokens.
+func (x *Index) Len() int {
+	return len(x.Offsets)
+}
+
+// Offset returns the start and stop offsets of the nth token, starting at 0.
+func (x *Index) Offset(n int) ([2]int64, error) {
+	if n < 0 || n >= len(x.Offsets) {
+		return [2]int64{}, fmt.Errorf("invalid token number %d: the index holds %d tokens", n, len(x.Offsets))
+	}
+	return x.Offsets[n], nil
+}
+
+// Segments returns segments holding bulkLen tokens each, as ChunkAllExact does,
+// without reading the source.
+func (x *Index) Segments(bulkLen int) ([][2]int64, []int, error) {
+
+	if bulkLen <= 0 {
+		return nil, nil, fmt.Errorf("invalid bulk length %d", bulkLen)
+	}
+
+	return x.segments(countFull(bulkLen))
+}
+
+// SegmentsBytes returns segments of at least size bytes, as ChunkAllBytes does,
+// without reading the source.
+func (x *Index) SegmentsBytes(size int64) ([][2]int64, []int, error) {
+
+	if size <= 0 {
+		return nil, nil, fmt.Errorf("invalid segment size %d", size)
+	}
+
+	return x.segments(sizeFull(size))
+}
+
+// segments groups the tokens into segments.
+func (x *Index) segments(full func([2]int64, int) bool) ([][2]int64, []int, error) {
+
+	if len(x.Offsets) == 0 {
+		return nil, nil, fmt.Errorf("cannot find token \"%s\" in reader", x.Token)
+	}
+
+	s := segmenter{full: full}
+	for _, o := range x.Offsets {
+		s.add(o[0], o[1])
+	}
+	return s.segments, s.counts, nil
+}
+
+// WriteTo writes the binary representation of the index. Offsets are written as
+// variable-length deltas, so the representation is usually much smaller than the
+// offsets themselves.
+func (x *Index) WriteTo(w io.Writer) (int64, error) {
+
+	var modTime int64
+	if !x.ModTime.IsZero() {
+		modTime = x.ModTime.UnixNano()
+	}
+
+	var scratch [bin.MaxVarintLen64]byte
+	buf := []byte(indexMagic)
+	buf = append(buf, scratch[:bin.PutUvarint(scratch[:], uint64(len(x.Token)))]...)
+	buf = append(buf, x.Token...)
+	buf = append(buf, scratch[:bin.PutVarint(scratch[:], x.Size)]...)
+	buf = append(buf, scratch[:bin.PutVarint(scratch[:], modTime)]...)
+	bin.BigEndian.PutUint32(scratch[:], x.Checksum)
+	buf = append(buf, scratch[:4]...)
+	buf = append(buf, scratch[:bin.PutUvarint(scratch[:], uint64(len(x.Offsets)))]...)
+
+	var n int64
+	var last int64
+	for _, o := range x.Offsets {
+		buf = append(buf, scratch[:bin.PutVarint(scratch[:], o[0]-last)]...)
+		buf = append(buf, scratch[:bin.PutVarint(scratch[:], o[1]-o[0])]...)
+		last = o[1]
+
+		if len(buf) >= 64*1024 {
+			written, err := w.Write(buf)
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+			buf = buf[:0]
+		}
+	}
+
+	written, err := w.Write(buf)
+	return n + int64(written), err
+}
+
+// ReadIndex reads an index written by WriteTo. Indexes whose offsets are not
+// ordered within the indexed size are invalid.
+func ReadIndex(reader io.Reader) (*Index, error) {
+
+	r, ok := reader.(io.ByteReader)
+	if !ok {
+		r = bufio.NewReader(reader)
+	}
+	invalid := func(err error) (*Index, error) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("invalid index: %s", err)
+	}
+
+	magic := make([]byte, len(indexMagic))
+	for i := range magic {
+		c, err := r.ReadByte()
+		if err != nil {
+			return invalid(err)
+		}
+		magic[i] = c
+	}
+	if string(magic) != indexMagic {
+		return nil, fmt.Errorf("invalid index: unknown format")
+	}
+
+	x := &Index{}
+	length, err := bin.ReadUvarint(r)
+	if err != nil {
+		return invalid(err)
+	}
+	if length > 1<<16 {
+		return nil, fmt.Errorf("invalid index: token too long")
+	}
+	token := make([]byte, length)
+	for i := range token {
+		token[i], err = r.ReadByte()
+		if err != nil {
+			return invalid(err)
+		}
+	}
+	x.Token = string(token)
+
+	x.Size, err = bin.ReadVarint(r)
+	if err != nil {
+		return invalid(err)
+	}
+	modTime, err := bin.ReadVarint(r)
+	if err != nil {
+		return invalid(err)
+	}
+	if modTime != 0 {
+		x.ModTime = time.Unix(0, modTime)
+	}
+	for i := 0; i < 4; i++ {
+		c, err := r.ReadByte()
+		if err != nil {
+			return invalid(err)
+		}
+		x.Checksum = x.Checksum<<8 | uint32(c)
+	}
+
+	count, err := bin.ReadUvarint(r)
+	if err != nil {
+		return invalid(err)
+	}
+	if x.Size < 0 || count > uint64(x.Size)/4 {
+		return nil, fmt.Errorf("invalid index: too many offsets")
+	}
+
+	if count != 0 {
+		x.Offsets = make([][2]int64, count)
+	}
+	var last int64
+	for i := range x.Offsets {
+		gap, err := bin.ReadVarint(r)
+		if err != nil {
+			return invalid(err)
+		}
+		size, err := bin.ReadVarint(r)
+		if err != nil {
+			return invalid(err)
+		}
+		if gap < 0 || size < 0 || gap > x.Size-last || size > x.Size-last-gap {
+			return nil, fmt.Errorf("invalid index: offset out of range")
+		}
+		start := last + gap
+		x.Offsets[i] = [2]int64{start, start + size}
+		last = start + size
+	}
+
+	return x, nil
+}
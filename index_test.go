@@ -0,0 +1,429 @@
+package xmlx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func Test_TokenOffsets(t *testing.T) {
+
+	for label, c := range map[string]struct {
+		in  string
+		out []string
+	}{
+		"plain": {
+			in:  `<music><song>One</song><song a="1"/><song>Three</song></music>`,
+			out: []string{`<song>One</song>`, `<song a="1"/>`, `<song>Three</song>`},
+		},
+		"nested": {
+			in:  `<music><song><song>inner</song><b><song/></b></song><other><song>2</song></other></music>`,
+			out: []string{`<song><song>inner</song><b><song/></b></song>`, `<song>2</song>`},
+		},
+		"prefixed": {
+			in:  `<m:music xmlns:m="urn:m"><m:song>1</m:song><song>2</song ></m:music>`,
+			out: []string{`<m:song>1</m:song>`, `<song>2</song >`},
+		},
+		"markup in text": {
+			in: `<?xml version="1.0"?><!DOCTYPE music [<!ELEMENT song (#PCDATA)><!-- <song> -->]>` +
+				`<music><!-- <song>comment</song> --><song><![CDATA[</song><song>]]></song>` +
+				`<?song <song>?><song title="a > b" alt='</song>'>2</song></music>`,
+			out: []string{`<song><![CDATA[</song><song>]]></song>`, `<song title="a > b" alt='</song>'>2</song>`},
+		},
+		"none": {
+			in: `<music><album/></music>`,
+		},
+	} {
+		offsets, err := TokenOffsets(iotest.HalfReader(strings.NewReader(c.in)), "song")
+		if err != nil {
+			t.Logf("failed case %s: %s", label, err)
+			t.Fail()
+			continue
+		}
+
+		var out []string
+		for _, o := range offsets {
+			out = append(out, c.in[o[0]:o[1]])
+		}
+		if !reflect.DeepEqual(out, c.out) {
+			t.Logf("failed case %s", label)
+			t.Logf("having: %q", out)
+			t.Logf("expected: %q", c.out)
+			t.Fail()
+		}
+	}
+
+	for _, in := range []string{
+		`<music><song>One`,
+		`<music><song a="1>`,
+		`<music><!-- song`,
+		`<music><![CDATA[ song`,
+		`<music>< song/></music>`,
+	} {
+		_, err := TokenOffsets(strings.NewReader(in), "song")
+		if err == nil {
+			t.Logf("expected an error for %q", in)
+			t.Fail()
+		}
+	}
+}
+
+func Test_TokenOffsetsLegacy(t *testing.T) {
+
+	// Both approaches agree on inputs the former one handles.
+	for _, in := range []string{queryInput, parallelInput(1000)} {
+		offsets, err := TokenOffsets(strings.NewReader(in), "song")
+		if err != nil {
+			t.Log("unexpected error", err)
+			t.FailNow()
+		}
+
+		var offset int64
+		for i, o := range offsets {
+			expected, err := chunkSeek(strings.NewReader(in), "song", offset)
+			if err != nil || o != expected {
+				t.Logf("offset %d: having %v, expected %v (%v)", i, o, expected, err)
+				t.Fail()
+				break
+			}
+			offset = expected[1]
+		}
+	}
+}
+
+// chunkSeek is the former implementation of Chunk.
+func chunkSeek(reader io.ReadSeeker, token string, offset int64) ([2]int64, error) {
+	start, err := nextStartOffset(context.Background(), reader, token, offset)
+	if err != nil {
+		return [2]int64{}, err
+	}
+	stop, err := nextStopOffset(context.Background(), reader, token, start)
+	if err != nil {
+		return [2]int64{}, err
+	}
+	return [2]int64{start, stop}, nil
+}
+
+// syntheticReader generates a document made of records of various sizes, without
+// holding it in memory.
+type syntheticReader struct {
+	head, block, tail string
+	count             int64 // the number of blocks of records
+	pos               int64
+}
+
+// newSyntheticReader returns a reader of about size bytes, made of blocks of 16 records.
+func newSyntheticReader(size int64) *syntheticReader {
+	r := &syntheticReader{head: `<?xml version="1.0"?><music><songs>`, tail: `</songs></music>`}
+	for i := 0; i < 16; i++ {
+		r.block += fmt.Sprintf(`<song number="%d"><name>%s</name><!-- <song> --></song>`, i, strings.Repeat("la ", 20+i*i*5))
+	}
+	r.count = size / int64(len(r.block))
+	return r
+}
+
+// Size returns the size of the document.
+func (r *syntheticReader) Size() int64 {
+	return int64(len(r.head)+len(r.tail)) + r.count*int64(len(r.block))
+}
+
+func (r *syntheticReader) ReadAt(p []byte, off int64) (int, error) {
+	var n int
+	head := int64(len(r.head))
+	records := r.count * int64(len(r.block))
+	for n < len(p) {
+		pos := off + int64(n)
+		switch {
+		case pos < head:
+			n += copy(p[n:], r.head[pos:])
+		case pos < head+records:
+			n += copy(p[n:], r.block[(pos-head)%int64(len(r.block)):])
+		case pos < r.Size():
+			n += copy(p[n:], r.tail[pos-head-records:])
+		default:
+			return n, io.EOF
+		}
+	}
+	return n, nil
+}
+
+func (r *syntheticReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *syntheticReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.Size()
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func Test_syntheticReader(t *testing.T) {
+
+	r := newSyntheticReader(1 << 16)
+	var b strings.Builder
+	_, err := io.Copy(&b, r)
+	if err != nil || int64(b.Len()) != r.Size() {
+		t.Logf("having %d bytes and error %v", b.Len(), err)
+		t.FailNow()
+	}
+
+	offsets, err := TokenOffsets(strings.NewReader(b.String()), "song")
+	if err != nil || int64(len(offsets)) != 16*r.count {
+		t.Logf("having %d records and error %v", len(offsets), err)
+		t.Fail()
+	}
+}
+
+// benchmarkChunkAll runs the chunk function on synthetic documents of various sizes.
+func benchmarkChunkAll(b *testing.B, chunk func(io.ReadSeeker) ([][2]int64, error)) {
+	for _, size := range []struct {
+		name string
+		size int64
+	}{
+		{name: "16MB", size: 16 << 20},
+		{name: "1GB", size: 1 << 30},
+	} {
+		b.Run(size.name, func(b *testing.B) {
+			r := newSyntheticReader(size.size)
+			b.SetBytes(r.Size())
+			for i := 0; i < b.N; i++ {
+				_, err := chunk(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_ChunkAll(b *testing.B) {
+	benchmarkChunkAll(b, func(r io.ReadSeeker) ([][2]int64, error) {
+		return ChunkAll(r, "song", 1000)
+	})
+}
+
+func Benchmark_ChunkAllSeek(b *testing.B) {
+	benchmarkChunkAll(b, func(r io.ReadSeeker) ([][2]int64, error) {
+		return chunkAllSeek(context.Background(), r, "song", 1000, ChunkOptions{})
+	})
+}
+
+func Benchmark_TokenOffsets(b *testing.B) {
+	benchmarkChunkAll(b, func(r io.ReadSeeker) ([][2]int64, error) {
+		_, err := r.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		return TokenOffsets(r, "song")
+	})
+}
+
+func Test_Index(t *testing.T) {
+
+	input := parallelInput(500)
+	x, err := BuildIndex(strings.NewReader(input), "song")
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	offsets, _ := TokenOffsets(strings.NewReader(input), "song")
+	if !reflect.DeepEqual(x.Offsets, offsets) || x.Len() != 500 || x.Size != int64(len(input)) || x.Token != "song" {
+		t.Logf("having: %d offsets, size %d, token %q", x.Len(), x.Size, x.Token)
+		t.Fail()
+	}
+
+	// Segments match the ones computed from the source.
+	for _, bulkLen := range []int{1, 7, 1000} {
+		segments, counts, err := x.Segments(bulkLen)
+		expected, expectedCounts, _ := ChunkAllExact(strings.NewReader(input), "song", bulkLen)
+		if err != nil || !reflect.DeepEqual(segments, expected) || !reflect.DeepEqual(counts, expectedCounts) {
+			t.Logf("bulk length %d: segments differ (%v)", bulkLen, err)
+			t.Fail()
+		}
+	}
+	segments, counts, err := x.SegmentsBytes(1000)
+	expected, expectedCounts, _ := ChunkAllBytes(strings.NewReader(input), "song", 1000)
+	if err != nil || !reflect.DeepEqual(segments, expected) || !reflect.DeepEqual(counts, expectedCounts) {
+		t.Logf("segments differ (%v)", err)
+		t.Fail()
+	}
+
+	o, err := x.Offset(41)
+	if err != nil || !strings.HasPrefix(input[o[0]:o[1]], `<song number="42">`) {
+		t.Logf("having %v and error %v", o, err)
+		t.Fail()
+	}
+	for _, n := range []int{-1, 500} {
+		_, err := x.Offset(n)
+		if err == nil {
+			t.Logf("expected an error for token %d", n)
+			t.Fail()
+		}
+	}
+
+	if err := x.Verify(strings.NewReader(input)); err != nil {
+		t.Log("unexpected error", err)
+		t.Fail()
+	}
+	if err := x.Verify(strings.NewReader(strings.Replace(input, "Song 1<", "Song 9<", 1))); err == nil {
+		t.Log("expected an error for a modified source")
+		t.Fail()
+	}
+}
+
+func Test_IndexWriteTo(t *testing.T) {
+
+	x, err := BuildIndex(strings.NewReader(parallelInput(500)), "song")
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+	x.ModTime = time.Date(2019, 3, 1, 10, 0, 0, 42, time.UTC)
+
+	for _, index := range []*Index{x, {Token: "empty"}} {
+		var b bytes.Buffer
+		n, err := index.WriteTo(&b)
+		if err != nil || n != int64(b.Len()) {
+			t.Logf("having %d bytes and error %v", n, err)
+			t.Fail()
+			continue
+		}
+		data := b.Bytes()
+
+		having, err := ReadIndex(&b)
+		if err != nil {
+			t.Log("unexpected error", err)
+			t.Fail()
+			continue
+		}
+		if !having.ModTime.Equal(index.ModTime) {
+			t.Logf("having time %v, expected %v", having.ModTime, index.ModTime)
+			t.Fail()
+		}
+		having.ModTime = index.ModTime
+		if !reflect.DeepEqual(having, index) {
+			t.Logf("having: %+v", having)
+			t.Logf("expected: %+v", index)
+			t.Fail()
+		}
+
+		// Truncated indexes are invalid.
+		for _, size := range []int{0, 5, len(indexMagic) + 2, len(data) - 1} {
+			if size < 0 || size >= len(data) {
+				continue
+			}
+			_, err := ReadIndex(bytes.NewReader(data[:size]))
+			if err == nil {
+				t.Logf("expected an error for %d bytes", size)
+				t.Fail()
+			}
+		}
+	}
+
+	// Corrupted offsets are invalid.
+	for i, offsets := range [][][2]int64{
+		{{10, 20}, {15, 30}},
+		{{10, 5}},
+		{{10, 20}, {90, 101}},
+		{{-1, 20}},
+	} {
+		var b bytes.Buffer
+		_, err := (&Index{Token: "song", Size: 100, Offsets: offsets}).WriteTo(&b)
+		if err != nil {
+			t.Log("unexpected error", err)
+			t.FailNow()
+		}
+		_, err = ReadIndex(&b)
+		if err == nil || !strings.Contains(err.Error(), "invalid index") {
+			t.Logf("failed case %d: having error %v", i+1, err)
+			t.Fail()
+		}
+	}
+}
+
+func Test_OpenIndex(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xmlx")
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "music.xml")
+	index := filepath.Join(dir, "music.idx")
+	err = ioutil.WriteFile(name, []byte(parallelInput(100)), 0644)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	// The index is built and saved.
+	x, err := OpenIndex(name, "song", index)
+	if err != nil || x.Len() != 100 {
+		t.Logf("having %v and error %v", x, err)
+		t.FailNow()
+	}
+	info, _ := os.Stat(name)
+	if !x.Matches(info) {
+		t.Log("expected the index to match the file")
+		t.Fail()
+	}
+
+	// The saved index is used as long as it matches the file.
+	saved := *x
+	saved.Offsets = saved.Offsets[:10]
+	var b bytes.Buffer
+	saved.WriteTo(&b)
+	ioutil.WriteFile(index, b.Bytes(), 0644)
+
+	x, err = OpenIndex(name, "song", index)
+	if err != nil || x.Len() != 10 {
+		t.Logf("having %v and error %v", x, err)
+		t.Fail()
+	}
+
+	// The index is built again for another token, or a modified file.
+	x, err = OpenIndex(name, "name", index)
+	if err != nil || x.Len() != 100 || x.Token != "name" {
+		t.Logf("having %v and error %v", x, err)
+		t.Fail()
+	}
+
+	err = ioutil.WriteFile(name, []byte(parallelInput(50)), 0644)
+	if err == nil {
+		err = os.Chtimes(name, time.Now(), info.ModTime().Add(time.Second))
+	}
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+	x, err = OpenIndex(name, "name", index)
+	if err != nil || x.Len() != 50 {
+		t.Logf("having %v and error %v", x, err)
+		t.Fail()
+	}
+
+	_, err = OpenIndex(filepath.Join(dir, "missing.xml"), "song", index)
+	if err == nil {
+		t.Log("expected an error for a missing file")
+		t.Fail()
+	}
+}
@@ -0,0 +1,504 @@
+package xmlx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Convention is a convention used to convert nodes into JSON.
+type Convention int
+
+// Supported conventions. For instance, the node <a x="1">t<b>u</b><b>v</b></a>
+// is converted into:
+//
+//	AttrText:   {"a":{"@x":"1","#text":"t","b":["u","v"]}}
+//	BadgerFish: {"a":{"@x":"1","$":"t","b":[{"$":"u"},{"$":"v"}]}}
+//	Parker:     {"b":["u","v"]}
+//	GData:      {"a":{"x":"1","$t":"t","b":[{"$t":"u"},{"$t":"v"}]}}
+//
+// In every convention, repeated subnodes are grouped into an array located at
+// the position of the first one. AttrText and Parker convert empty nodes into
+// null, and nodes only holding data into strings. Parker drops the name of
+// the root, the attributes and the data of nodes having subnodes. GData replaces
+// the colon of prefixed names by a dollar sign. Comments and processing
+// instructions are always dropped.
+const (
+	AttrText Convention = iota
+	BadgerFish
+	Parker
+	GData
+)
+
+// JSONEncoder writes nodes as JSON into an output stream.
+type JSONEncoder struct {
+	enc        *json.Encoder
+	convention Convention
+}
+
+// NewJSONEncoder returns a new encoder that writes to w, following the given convention.
+func NewJSONEncoder(w io.Writer, c Convention) *JSONEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &JSONEncoder{enc: enc, convention: c}
+}
+
+// SetIndent instructs the encoder to indent the output, as json.Encoder does.
+func (e *JSONEncoder) SetIndent(prefix, indent string) {
+	e.enc.SetIndent(prefix, indent)
+}
+
+// Encode writes the JSON representation of the node, followed by a newline.
+func (e *JSONEncoder) Encode(n Node) error {
+	return e.enc.Encode(toJSON(n, e.convention))
+}
+
+// MarshalJSON returns the JSON representation of the node, following the AttrText convention.
+func (n Node) MarshalJSON() ([]byte, error) {
+	return json.Marshal(toJSON(n, AttrText))
+}
+
+// toJSON returns the JSON value of the node, wrapped into an object named after
+// the node, except for the Parker convention.
+func toJSON(n Node, c Convention) interface{} {
+
+	var s scope
+	if c == Parker {
+		return c.value(n, s)
+	}
+
+	return jsonObject{{key: c.name(n.qname()), value: c.value(n, s)}}
+}
+
+// name returns the key of a qualified name.
+func (c Convention) name(qname string) string {
+	if c == GData {
+		return strings.Replace(qname, ":", "$", 1)
+	}
+	return qname
+}
+
+// value returns the JSON value of the content of the node.
+func (c Convention) value(n Node, s scope) interface{} {
+
+	s = s.with(n)
+	if n.Space != "" {
+		s = append(s, binding{prefix: n.Prefix, uri: n.Space})
+	}
+
+	var members jsonObject
+	if c != Parker {
+		members = append(members, c.declarations(n)...)
+		members = append(members, c.attributes(n, s)...)
+		if n.Data != "" {
+			members = append(members, jsonMember{key: c.textKey(), value: n.Data})
+		}
+	}
+
+	// Group the subnodes by name, at the position of the first one.
+	var keys []string
+	children := map[string][]interface{}{}
+	for _, child := range n.Nodes {
+		key := c.name(child.qname())
+		if _, ok := children[key]; !ok {
+			keys = append(keys, key)
+		}
+		children[key] = append(children[key], c.value(child, s))
+	}
+
+	for _, k := range keys {
+		if len(children[k]) == 1 {
+			members = append(members, jsonMember{key: k, value: children[k][0]})
+			continue
+		}
+		members = append(members, jsonMember{key: k, value: children[k]})
+	}
+
+	// Simplify the nodes that do not need an object.
+	if c == AttrText && len(members) == 1 && members[0].key == c.textKey() {
+		return n.Data
+	}
+	if c == Parker && len(n.Nodes) == 0 && n.Data != "" {
+		return n.Data
+	}
+	if (c == AttrText || c == Parker) && len(members) == 0 {
+		return nil
+	}
+	if members == nil {
+		members = jsonObject{}
+	}
+
+	return members
+}
+
+// textKey returns the key of the data.
+func (c Convention) textKey() string {
+	switch c {
+	case BadgerFish:
+		return "$"
+	case GData:
+		return "$t"
+	}
+	return "#text"
+}
+
+// attrKey returns the key of an attribute.
+func (c Convention) attrKey(name string) string {
+	if c == GData {
+		return c.name(name)
+	}
+	return "@" + name
+}
+
+// declarations returns the JSON members of the namespace declarations of the node.
+func (c Convention) declarations(n Node) jsonObject {
+
+	var prefixes []string
+	for p := range n.Namespaces {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	var members jsonObject
+	if c == BadgerFish {
+		var ns jsonObject
+		for _, p := range prefixes {
+			key := p
+			if key == "" {
+				key = "$"
+			}
+			ns = append(ns, jsonMember{key: key, value: n.Namespaces[p]})
+		}
+		if ns != nil {
+			members = append(members, jsonMember{key: "@xmlns", value: ns})
+		}
+		return members
+	}
+
+	for _, p := range prefixes {
+		name := "xmlns"
+		if p != "" {
+			name += ":" + p
+		}
+		members = append(members, jsonMember{key: c.attrKey(name), value: n.Namespaces[p]})
+	}
+	return members
+}
+
+// attributes returns the JSON members of the attributes of the node. Attribute
+// names are qualified with the prefix bound to their namespace in the scope, or
+// with the prefix they were written with.
+func (c Convention) attributes(n Node, s scope) jsonObject {
+
+	var names []string
+	for k := range n.Attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var members jsonObject
+	for _, k := range names {
+		members = append(members, jsonMember{key: c.attrKey(n.attrName(k, s)), value: n.Attrs[k]})
+	}
+	return members
+}
+
+// jsonObject is a JSON object that keeps the order of its members.
+type jsonObject []jsonMember
+
+// jsonMember is a member of a JSON object.
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+// MarshalJSON writes the members of the object in order.
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, m := range o {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(m.key)
+		if err != nil {
+			return nil, err
+		}
+		b.Truncate(b.Len() - 1)
+		b.WriteByte(':')
+		err = enc.Encode(m.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Truncate(b.Len() - 1)
+	}
+	b.WriteByte('}')
+
+	return b.Bytes(), nil
+}
+
+// JSONDecoder reads nodes from a JSON input stream.
+type JSONDecoder struct {
+	dec        *json.Decoder
+	convention Convention
+}
+
+// NewJSONDecoder returns a new decoder that reads from r, following the given
+// convention. Since the Parker convention drops the name of the root, nodes
+// decoded with it have no name.
+func NewJSONDecoder(r io.Reader, c Convention) *JSONDecoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &JSONDecoder{dec: dec, convention: c}
+}
+
+// Decode reads the next JSON value from the input and stores the node it
+// represents in n. Members are converted in order, and arrays become repeated
+// subnodes. Numbers and booleans become data, and null becomes an empty node.
+func (d *JSONDecoder) Decode(n *Node) error {
+
+	v, err := readJSON(d.dec)
+	if err != nil {
+		return err
+	}
+
+	node, err := fromJSON(v, d.convention)
+	if err != nil {
+		return err
+	}
+
+	*n = node
+	return nil
+}
+
+// UnmarshalJSON reads the node from its JSON representation, following the AttrText convention.
+func (n *Node) UnmarshalJSON(b []byte) error {
+	return NewJSONDecoder(bytes.NewReader(b), AttrText).Decode(n)
+}
+
+// readJSON reads a JSON value from the decoder. Objects are read as jsonObject,
+// to keep the order of their members.
+func readJSON(dec *json.Decoder) (interface{}, error) {
+
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		o := jsonObject{}
+		for dec.More() {
+			k, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := readJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, jsonMember{key: k.(string), value: v})
+		}
+		_, err := dec.Token()
+		return o, err
+
+	case json.Delim('['):
+		a := []interface{}{}
+		for dec.More() {
+			v, err := readJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err := dec.Token()
+		return a, err
+	}
+
+	return t, nil
+}
+
+// fromJSON returns the node represented by the JSON value.
+func fromJSON(v interface{}, c Convention) (Node, error) {
+
+	if c == Parker {
+		return c.node("", v, nil)
+	}
+
+	o, ok := v.(jsonObject)
+	if !ok || len(o) != 1 {
+		return Node{}, fmt.Errorf("expected an object with a single member")
+	}
+
+	return c.node(c.qname(o[0].key), o[0].value, nil)
+}
+
+// qname returns the qualified name of a key.
+func (c Convention) qname(key string) string {
+	if c == GData {
+		return strings.Replace(key, "$", ":", 1)
+	}
+	return key
+}
+
+// node returns the node having the given qualified name and represented by the
+// JSON value. The scope contains the namespaces declared by the ancestors.
+func (c Convention) node(qname string, v interface{}, s scope) (Node, error) {
+
+	var n Node
+	o, isObject := v.(jsonObject)
+
+	// Declarations come first, so that the names can be resolved.
+	if isObject {
+		for _, m := range o {
+			err := c.declare(&n, m)
+			if err != nil {
+				return Node{}, err
+			}
+		}
+	}
+	s = s.with(n)
+
+	_, n.Prefix, n.Name = splitName(qname)
+	n.Space, _ = s.uri(n.Prefix)
+
+	switch t := v.(type) {
+	case nil:
+		return n, nil
+	case string:
+		n.Data = t
+		return n, nil
+	case json.Number:
+		n.Data = t.String()
+		return n, nil
+	case bool:
+		n.Data = fmt.Sprint(t)
+		return n, nil
+	case []interface{}:
+		return Node{}, fmt.Errorf("unexpected array in %q", qname)
+	}
+
+	for _, m := range o {
+		if c.isDeclaration(m) {
+			continue
+		}
+
+		switch {
+		case c != Parker && m.key == c.textKey():
+			data, err := jsonString(m.key, m.value)
+			if err != nil {
+				return Node{}, err
+			}
+			n.Data = data
+
+		case c == GData && !isJSONContainer(m.value), c != Parker && c != GData && strings.HasPrefix(m.key, "@"):
+			name := strings.TrimPrefix(c.qname(m.key), "@")
+			if _, prefix, local := splitName(name); prefix != "" {
+				if uri, ok := s.uri(prefix); ok {
+					name = "{" + uri + "}" + local
+					n.setAttrPrefix(name, prefix)
+				}
+			}
+			value, err := jsonString(m.key, m.value)
+			if err != nil {
+				return Node{}, err
+			}
+			if n.Attrs == nil {
+				n.Attrs = map[string]string{}
+			}
+			n.Attrs[name] = value
+
+		default:
+			values, ok := m.value.([]interface{})
+			if !ok {
+				values = []interface{}{m.value}
+			}
+			for _, value := range values {
+				child, err := c.node(c.qname(m.key), value, s)
+				if err != nil {
+					return Node{}, err
+				}
+				n.Nodes = append(n.Nodes, child)
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// isDeclaration returns true if the member is a namespace declaration.
+func (c Convention) isDeclaration(m jsonMember) bool {
+	switch c {
+	case AttrText:
+		return m.key == "@xmlns" || strings.HasPrefix(m.key, "@xmlns:")
+	case BadgerFish:
+		return m.key == "@xmlns"
+	case GData:
+		return m.key == "xmlns" || strings.HasPrefix(m.key, "xmlns$")
+	}
+	return false
+}
+
+// declare adds the namespace declarations of the member to the node.
+func (c Convention) declare(n *Node, m jsonMember) error {
+
+	if !c.isDeclaration(m) {
+		return nil
+	}
+
+	if c == BadgerFish {
+		o, ok := m.value.(jsonObject)
+		if !ok {
+			return fmt.Errorf("expected an object in %q", m.key)
+		}
+		for _, d := range o {
+			prefix := d.key
+			if prefix == "$" {
+				prefix = ""
+			}
+			uri, err := jsonString(d.key, d.value)
+			if err != nil {
+				return err
+			}
+			n.declare(prefix, uri)
+		}
+		return nil
+	}
+
+	uri, err := jsonString(m.key, m.value)
+	if err != nil {
+		return err
+	}
+	name := c.qname(strings.TrimPrefix(m.key, "@"))
+	n.declare(strings.TrimPrefix(strings.TrimPrefix(name, "xmlns"), ":"), uri)
+	return nil
+}
+
+// jsonString returns the string representation of the JSON scalar held by the
+// member key. Objects and arrays have none.
+func jsonString(key string, v interface{}) (string, error) {
+	switch v.(type) {
+	case nil:
+		return "", nil
+	case jsonObject:
+		return "", fmt.Errorf("unexpected object in %q", key)
+	case []interface{}:
+		return "", fmt.Errorf("unexpected array in %q", key)
+	}
+	return fmt.Sprint(v), nil
+}
+
+// isJSONContainer returns true if the value is an object or an array.
+func isJSONContainer(v interface{}) bool {
+	switch v.(type) {
+	case jsonObject, []interface{}:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,232 @@
+package xmlx
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+// jsonCases are XML inputs along with their JSON representation in each convention.
+var jsonCases = []struct {
+	input string
+	json  map[Convention]string
+}{
+	{
+		input: `<a x="1">t<b>u</b><b>v</b></a>`,
+		json: map[Convention]string{
+			AttrText:   `{"a":{"@x":"1","#text":"t","b":["u","v"]}}`,
+			BadgerFish: `{"a":{"@x":"1","$":"t","b":[{"$":"u"},{"$":"v"}]}}`,
+			Parker:     `{"b":["u","v"]}`,
+			GData:      `{"a":{"x":"1","$t":"t","b":[{"$t":"u"},{"$t":"v"}]}}`,
+		},
+	},
+	{
+		input: `
+<music>
+	<album name="Black Album">
+		<meta>
+			<band>Metallica &amp; friends</band>
+			<year>1991</year>
+		</meta>
+		<song>Enter Sandman</song>
+		<bonus/>
+		<song>Sad but True</song>
+	</album>
+</music>`,
+		json: map[Convention]string{
+			AttrText:   `{"music":{"album":{"@name":"Black Album","meta":{"band":"Metallica & friends","year":"1991"},"song":["Enter Sandman","Sad but True"],"bonus":null}}}`,
+			BadgerFish: `{"music":{"album":{"@name":"Black Album","meta":{"band":{"$":"Metallica & friends"},"year":{"$":"1991"}},"song":[{"$":"Enter Sandman"},{"$":"Sad but True"}],"bonus":{}}}}`,
+			Parker:     `{"album":{"meta":{"band":"Metallica & friends","year":"1991"},"song":["Enter Sandman","Sad but True"],"bonus":null}}`,
+			GData:      `{"music":{"album":{"name":"Black Album","meta":{"band":{"$t":"Metallica & friends"},"year":{"$t":"1991"}},"song":[{"$t":"Enter Sandman"},{"$t":"Sad but True"}],"bonus":{}}}}`,
+		},
+	},
+	{
+		input: `<feed xmlns="http://www.w3.org/2005/Atom" xmlns:gd="urn:gd"><entry gd:etag="W/1"><gd:id>7</gd:id></entry></feed>`,
+		json: map[Convention]string{
+			AttrText:   `{"feed":{"@xmlns":"http://www.w3.org/2005/Atom","@xmlns:gd":"urn:gd","entry":{"@gd:etag":"W/1","gd:id":"7"}}}`,
+			BadgerFish: `{"feed":{"@xmlns":{"$":"http://www.w3.org/2005/Atom","gd":"urn:gd"},"entry":{"@gd:etag":"W/1","gd:id":{"$":"7"}}}}`,
+			Parker:     `{"entry":{"gd:id":"7"}}`,
+			GData:      `{"feed":{"xmlns":"http://www.w3.org/2005/Atom","xmlns$gd":"urn:gd","entry":{"gd$etag":"W/1","gd$id":{"$t":"7"}}}}`,
+		},
+	},
+}
+
+func Test_JSONEncoder(t *testing.T) {
+
+	for i, c := range jsonCases {
+		var node Node
+		err := xml.Unmarshal([]byte(c.input), &node)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		for _, convention := range []Convention{AttrText, BadgerFish, Parker, GData} {
+			var b bytes.Buffer
+			err := NewJSONEncoder(&b, convention).Encode(node)
+			if err != nil {
+				t.Logf("failed case %d, convention %d: %s", i+1, convention, err)
+				t.Fail()
+				continue
+			}
+
+			having := b.String()
+			expected := c.json[convention] + "\n"
+			if having != expected {
+				t.Logf("failed case %d, convention %d", i+1, convention)
+				t.Logf("having:   %s", having)
+				t.Logf("expected: %s", expected)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func Test_NodeMarshalJSON(t *testing.T) {
+
+	node := Node{
+		Name:  "a",
+		Attrs: map[string]string{"x": "<1>"},
+		Nodes: []Node{{Name: "b", Data: "u"}},
+	}
+
+	out, err := json.Marshal(node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	expected := `{"a":{"@x":"\u003c1\u003e","b":"u"}}`
+	if string(out) != expected {
+		t.Logf("having:   %s", out)
+		t.Logf("expected: %s", expected)
+		t.Fail()
+	}
+}
+
+func Test_JSONDecoder(t *testing.T) {
+
+	for i, c := range jsonCases {
+		var expected Node
+		err := xml.Unmarshal([]byte(c.input), &expected)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		for _, convention := range []Convention{AttrText, BadgerFish, Parker, GData} {
+
+			// JSON to node to JSON must be stable.
+			var node Node
+			err := NewJSONDecoder(bytes.NewBufferString(c.json[convention]), convention).Decode(&node)
+			if err != nil {
+				t.Logf("failed case %d, convention %d: %s", i+1, convention, err)
+				t.Fail()
+				continue
+			}
+
+			var b bytes.Buffer
+			err = NewJSONEncoder(&b, convention).Encode(node)
+			if err != nil {
+				t.Logf("failed case %d, convention %d: %s", i+1, convention, err)
+				t.Fail()
+				continue
+			}
+
+			if b.String() != c.json[convention]+"\n" {
+				t.Logf("failed case %d, convention %d", i+1, convention)
+				t.Logf("having:   %s", b.String())
+				t.Logf("expected: %s", c.json[convention])
+				t.Fail()
+			}
+
+			// Except for Parker, XML to JSON to XML must keep the node, apart
+			// from the order of mixed content and repeated subnodes.
+			if convention == Parker {
+				continue
+			}
+			if !reflect.DeepEqual(node, grouped(expected)) {
+				t.Logf("failed case %d, convention %d", i+1, convention)
+				t.Logf("having:\n\n%v\n", node)
+				t.Logf("expected:\n\n%v\n", expected)
+				t.Fail()
+			}
+		}
+	}
+
+	// Attributes and texts must be scalars.
+	invalid := map[Convention][]string{
+		AttrText:   {`{"a":{"@x":{"y":1},"#text":[1,2]}}`, `{"a":{"#text":[1,2]}}`, `{"a":{"@xmlns:p":[1]}}`},
+		BadgerFish: {`{"a":{"@x":{"y":1},"$":[1,2]}}`, `{"a":{"$":{"y":1}}}`, `{"a":{"@xmlns":{"p":{}}}}`},
+		GData:      {`{"a":{"$t":[1,2]}}`, `{"a":{"$t":{"y":1}}}`},
+	}
+	for convention, inputs := range invalid {
+		for _, input := range inputs {
+			var node Node
+			err := NewJSONDecoder(bytes.NewBufferString(input), convention).Decode(&node)
+			if err == nil {
+				t.Logf("expected an error for %s, convention %d", input, convention)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func Test_NodeUnmarshalJSON(t *testing.T) {
+
+	var node Node
+	err := json.Unmarshal([]byte(`{"a":{"@x":1,"#text":true,"b":[null,2.5]}}`), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	expected := Node{
+		Name:  "a",
+		Attrs: map[string]string{"x": "1"},
+		Data:  "true",
+		Nodes: []Node{
+			{Name: "b"},
+			{Name: "b", Data: "2.5"},
+		},
+	}
+	if !reflect.DeepEqual(node, expected) {
+		t.Logf("having:\n\n%v\n", node)
+		t.Logf("expected:\n\n%v\n", expected)
+		t.Fail()
+	}
+
+	for _, input := range []string{`[]`, `{"a":1,"b":2}`, `{"a":[[1]]}`, `{"a":`} {
+		err := json.Unmarshal([]byte(input), &node)
+		if err == nil {
+			t.Logf("expected an error for %s", input)
+			t.Fail()
+		}
+	}
+}
+
+// grouped returns the node as it is expected after a conversion to JSON: its
+// repeated subnodes follow the first one, and mixed content is dropped.
+func grouped(n Node) Node {
+
+	var names []string
+	groups := map[string][]Node{}
+	for _, child := range n.Nodes {
+		if _, ok := groups[child.qname()]; !ok {
+			names = append(names, child.qname())
+		}
+		groups[child.qname()] = append(groups[child.qname()], grouped(child))
+	}
+
+	n.Nodes = nil
+	for _, name := range names {
+		n.Nodes = append(n.Nodes, groups[name]...)
+	}
+	n.Content = nil
+
+	return n
+}
@@ -0,0 +1,290 @@
+package xmlx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MapOptions defines the syntax of the keys of a flatten representation. Empty
+// fields take their default value, so the zero MapOptions produces the keys of Map.
+//
+// For instance, keys such as "album/songs/song/@id" are produced with:
+//
+//	MapOptions{Separator: "/", AttrPrefix: "@", OmitName: true, OmitNodePrefix: true, OmitDataKey: true}
+type MapOptions struct {
+
+	// The separator between the segments of a key, "." by default
+	Separator string
+
+	// The key holding the name of a node, "#name" by default
+	NameKey string
+
+	// The key holding the data of a node, "#data" by default
+	DataKey string
+
+	// The prefix of attribute names, "#attr" followed by the separator by default
+	AttrPrefix string
+
+	// The prefix of subnode names, "#nodes" followed by the separator by default
+	NodePrefix string
+
+	// OmitName removes the keys holding the names of the nodes.
+	OmitName bool
+
+	// OmitNodePrefix removes the prefix of subnode names, so that keys are made
+	// of the names of the nodes separated by the separator.
+	OmitNodePrefix bool
+
+	// OmitDataKey puts the data of subnodes directly under their key, without
+	// the data key. The data of the node itself is still put under the data key.
+	OmitDataKey bool
+
+	// Indexed appends the index of each subnode among its siblings having the
+	// same name, as MapIndexed does.
+	Indexed bool
+
+	// Escape prefixes the separators and backslashes found in names with a
+	// backslash. Names containing the separator are otherwise written as is.
+	Escape bool
+}
+
+// withDefaults returns the options with their empty fields set to their default value.
+func (o MapOptions) withDefaults() MapOptions {
+	if o.Separator == "" {
+		o.Separator = "."
+	}
+	if o.NameKey == "" {
+		o.NameKey = "#name"
+	}
+	if o.DataKey == "" {
+		o.DataKey = "#data"
+	}
+	if o.AttrPrefix == "" {
+		o.AttrPrefix = "#attr" + o.Separator
+	}
+	if o.NodePrefix == "" {
+		o.NodePrefix = "#nodes" + o.Separator
+	}
+	return o
+}
+
+// escape escapes the separators and backslashes of the name, if enabled.
+func (o MapOptions) escape(name string) string {
+	if !o.Escape {
+		return name
+	}
+	name = strings.Replace(name, `\`, `\\`, -1)
+	return strings.Replace(name, o.Separator, `\`+o.Separator, -1)
+}
+
+// unescape reverts escape.
+func (o MapOptions) unescape(name string) string {
+	if !o.Escape {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+1 < len(name) {
+			i++
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// FromMap rebuilds a node from its flatten representation, as returned by Map
+// or MapIndexed.
+//
+// Since a map has no order, subnodes are sorted by their name as written in the
+// keys, then by index for the keys produced by MapIndexed. Indices only define
+// an order: missing indices do not produce empty nodes. The namespace URIs of
+// prefixed names are not part of the flatten representation: such names are
+// kept as written, in Prefix for nodes and as "prefix:local" keys for attributes.
+//
+// An error is returned if a key does not follow the syntax of Map, or if a
+// "#name" value does not match the name found in its key.
+func FromMap(m map[string]string) (Node, error) {
+	return FromMapWith(m, MapOptions{})
+}
+
+// FromMapWith rebuilds a node from its flatten representation, as FromMap does,
+// with keys following the given options.
+func FromMapWith(m map[string]string, o MapOptions) (Node, error) {
+
+	o = o.withDefaults()
+	root := &mapTree{}
+	for k, v := range m {
+		path, field, err := o.parseKey(k)
+		if err != nil {
+			return Node{}, err
+		}
+
+		tree := root
+		for _, segment := range path {
+			tree, err = tree.child(segment)
+			if err != nil {
+				return Node{}, fmt.Errorf("invalid key %q: %s", k, err)
+			}
+		}
+
+		err = tree.node.setField(o, field, v)
+		if err != nil {
+			return Node{}, fmt.Errorf("invalid key %q: %s", k, err)
+		}
+	}
+
+	return root.build(), nil
+}
+
+// mapTree is a node being rebuilt from a flatten representation.
+type mapTree struct {
+	node     Node
+	name     string
+	index    int
+	children map[string]*mapTree
+}
+
+// child returns the subtree matching the key segment, which is a name optionally
+// followed by an index, and creates it if it does not exist.
+func (t *mapTree) child(segment string) (*mapTree, error) {
+
+	if c, ok := t.children[segment]; ok {
+		return c, nil
+	}
+
+	c := &mapTree{name: segment}
+	if i := strings.LastIndex(segment, "["); i > 0 && strings.HasSuffix(segment, "]") {
+		index, err := strconv.Atoi(segment[i+1 : len(segment)-1])
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid index in %q", segment)
+		}
+		c.name, c.index = segment[:i], index
+	}
+	c.node.setName(c.name)
+
+	if t.children == nil {
+		t.children = map[string]*mapTree{}
+	}
+	t.children[segment] = c
+	return c, nil
+}
+
+// build returns the node of the tree, with its subnodes sorted by name and index.
+func (t *mapTree) build() Node {
+
+	var children []*mapTree
+	for _, c := range t.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].name != children[j].name {
+			return children[i].name < children[j].name
+		}
+		return children[i].index < children[j].index
+	})
+
+	node := t.node
+	for _, c := range children {
+		node.Nodes = append(node.Nodes, c.build())
+	}
+	return node
+}
+
+// parseKey splits a key of a flatten representation into the names of the
+// nodes leading to the value, and the field holding the value: the name key,
+// the data key, or an attribute name prefixed by the attribute prefix.
+func (o MapOptions) parseKey(key string) ([]string, string, error) {
+
+	var path []string
+	rest := key
+	for {
+		switch {
+		case rest == o.NameKey && !o.OmitName, rest == o.DataKey:
+			return path, rest, nil
+		case strings.HasPrefix(rest, o.AttrPrefix) && len(rest) > len(o.AttrPrefix):
+			return path, o.AttrPrefix + o.unescape(rest[len(o.AttrPrefix):]), nil
+		}
+
+		if !o.OmitNodePrefix {
+			if !strings.HasPrefix(rest, o.NodePrefix) {
+				return nil, "", fmt.Errorf("invalid key %q: unknown field %q", key, rest)
+			}
+			rest = rest[len(o.NodePrefix):]
+		}
+
+		var name string
+		name, rest = o.readName(rest)
+		if name == "" {
+			return nil, "", fmt.Errorf("invalid key %q: empty node name", key)
+		}
+		path = append(path, o.unescape(name))
+
+		if rest == "" {
+			if o.OmitDataKey {
+				return path, o.DataKey, nil
+			}
+			return nil, "", fmt.Errorf("invalid key %q: missing field after node %q", key, name)
+		}
+		rest = rest[len(o.Separator):]
+	}
+}
+
+// readName reads a node name at the start of s. The name stops before the first
+// separator that is neither escaped nor located within curly braces, and that is
+// followed by a valid segment. The rest of s, starting with the separator, is
+// returned along with the name.
+func (o MapOptions) readName(s string) (string, string) {
+
+	var depth int
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && o.Escape:
+			i++
+		case s[i] == '{':
+			depth++
+		case s[i] == '}' && depth > 0:
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], o.Separator):
+			next := s[i+len(o.Separator):]
+			if o.OmitNodePrefix || o.Escape || strings.HasPrefix(next, o.NodePrefix) ||
+				strings.HasPrefix(next, o.AttrPrefix) || next == o.NameKey || next == o.DataKey {
+				return s[:i], s[i:]
+			}
+		}
+	}
+
+	return s, ""
+}
+
+// setName sets the name of the node from a name written as "local",
+// "prefix:local" or "{uri}local".
+func (n *Node) setName(name string) {
+	n.Space, n.Prefix, n.Name = splitName(name)
+}
+
+// setField sets the field of the node to the value.
+func (n *Node) setField(o MapOptions, field, value string) error {
+
+	switch {
+	case field == o.NameKey:
+		var named Node
+		named.setName(value)
+		if n.Name != "" && (n.Name != named.Name || n.Prefix != named.Prefix || n.Space != named.Space) {
+			return fmt.Errorf("name %q does not match node %q", value, n.qname())
+		}
+		n.Name, n.Prefix, n.Space = named.Name, named.Prefix, named.Space
+
+	case field == o.DataKey:
+		n.Data = value
+
+	default:
+		if n.Attrs == nil {
+			n.Attrs = map[string]string{}
+		}
+		n.Attrs[field[len(o.AttrPrefix):]] = value
+	}
+
+	return nil
+}
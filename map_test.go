@@ -0,0 +1,264 @@
+package xmlx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_FromMap(t *testing.T) {
+
+	for i, c := range []struct {
+		in  map[string]string
+		out Node
+	}{
+		{
+			in: map[string]string{
+				"#name":                        "Paris",
+				"#attr.type":                   "city",
+				"#nodes.geo.#name":             "geo",
+				"#nodes.geo.#attr.mode":        "carthesian",
+				"#nodes.geo.#nodes.long.#name": "long",
+				"#nodes.geo.#nodes.long.#data": "13.4",
+				"#nodes.geo.#nodes.lat.#name":  "lat",
+				"#nodes.geo.#nodes.lat.#data":  "-2.41",
+				"#nodes.foo.#name":             "foo",
+				"#nodes.foo.#data":             "bar",
+			},
+			out: Node{
+				Name:  "Paris",
+				Attrs: map[string]string{"type": "city"},
+				Nodes: []Node{
+					{
+						Name: "foo",
+						Data: "bar",
+					},
+					{
+						Name:  "geo",
+						Attrs: map[string]string{"mode": "carthesian"},
+						Nodes: []Node{
+							{
+								Name: "lat",
+								Data: "-2.41",
+							},
+							{
+								Name: "long",
+								Data: "13.4",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			in: map[string]string{
+				"#name":                         "entry",
+				"#attr.a:id":                    "1",
+				"#attr.{urn:b}id":               "2",
+				"#nodes.a:id.#data":             "first",
+				"#nodes.{urn:b}id.#data":        "second",
+				"#nodes.config.value.#data":     "dotted",
+				"#nodes.config.value.#attr.x.y": "z",
+			},
+			out: Node{
+				Name:  "entry",
+				Attrs: map[string]string{"a:id": "1", "{urn:b}id": "2"},
+				Nodes: []Node{
+					{
+						Name:   "id",
+						Prefix: "a",
+						Data:   "first",
+					},
+					{
+						Name:  "config.value",
+						Data:  "dotted",
+						Attrs: map[string]string{"x.y": "z"},
+					},
+					{
+						Name:  "id",
+						Space: "urn:b",
+						Data:  "second",
+					},
+				},
+			},
+		},
+	} {
+		out, err := FromMap(c.in)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+		}
+
+		if !reflect.DeepEqual(out, c.out) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having:\n\n%v\n", out)
+			t.Logf("expected:\n\n%v\n", c.out)
+			t.Fail()
+		}
+
+		// The node must flatten back into the same map.
+		if i == 0 && !reflect.DeepEqual(out.Map(), c.in) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having map: %v", out.Map())
+			t.Fail()
+		}
+	}
+}
+
+func Test_FromMapInvalid(t *testing.T) {
+
+	for _, in := range []map[string]string{
+		{"": "foo"},
+		{"#nodes.band": "ACDC"},
+		{"#nodes..#data": "ACDC"},
+		{"#nodes.band.#value": "ACDC"},
+		{"#attr.": "x"},
+		{"name": "x"},
+		{"#nodes.band.#name": "size"},
+	} {
+		_, err := FromMap(in)
+		if err == nil {
+			t.Logf("expected an error for %v", in)
+			t.Fail()
+		}
+	}
+}
+
+func Test_FromMapIndexed(t *testing.T) {
+
+	in := Node{
+		Name: "songs",
+		Nodes: []Node{
+			{
+				Name: "count",
+				Data: "11",
+			},
+		},
+	}
+	for _, name := range []string{"One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine", "Ten", "Eleven"} {
+		in.Nodes = append(in.Nodes, Node{Name: "song", Data: name})
+	}
+
+	out, err := FromMap(in.MapIndexed())
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	if !reflect.DeepEqual(out, in) {
+		t.Logf("having:\n\n%v\n", out)
+		t.Logf("expected:\n\n%v\n", in)
+		t.Fail()
+	}
+
+	for _, key := range []string{"#nodes.song[x].#data", "#nodes.song[-1].#data"} {
+		_, err := FromMap(map[string]string{key: "x"})
+		if err == nil {
+			t.Logf("expected an error for %s", key)
+			t.Fail()
+		}
+	}
+}
+
+func Test_MapOptions(t *testing.T) {
+
+	in := Node{
+		Name:  "config",
+		Attrs: map[string]string{"version": "2"},
+		Nodes: []Node{
+			{
+				Name:  "config.value",
+				Attrs: map[string]string{"unit": "ms"},
+				Data:  "300",
+			},
+			{
+				Name: "server",
+				Nodes: []Node{
+					{
+						Name: "host",
+						Data: "localhost",
+					},
+				},
+			},
+		},
+	}
+
+	for i, c := range []struct {
+		options MapOptions
+		out     map[string]string
+	}{
+		{
+			options: MapOptions{Escape: true},
+			out: map[string]string{
+				"#name":                           "config",
+				"#attr.version":                   "2",
+				`#nodes.config\.value.#name`:      "config.value",
+				`#nodes.config\.value.#attr.unit`: "ms",
+				`#nodes.config\.value.#data`:      "300",
+				"#nodes.server.#name":             "server",
+				"#nodes.server.#nodes.host.#name": "host",
+				"#nodes.server.#nodes.host.#data": "localhost",
+			},
+		},
+		{
+			options: MapOptions{
+				Separator:      "/",
+				AttrPrefix:     "@",
+				OmitName:       true,
+				OmitNodePrefix: true,
+				OmitDataKey:    true,
+			},
+			out: map[string]string{
+				"@version":           "2",
+				"config.value/@unit": "ms",
+				"config.value":       "300",
+				"server/host":        "localhost",
+			},
+		},
+		{
+			options: MapOptions{
+				Separator:      "_",
+				NameKey:        "name",
+				DataKey:        "value",
+				AttrPrefix:     "attr_",
+				OmitNodePrefix: true,
+				Indexed:        true,
+			},
+			out: map[string]string{
+				"name":                      "config",
+				"attr_version":              "2",
+				"config.value[0]_name":      "config.value",
+				"config.value[0]_attr_unit": "ms",
+				"config.value[0]_value":     "300",
+				"server[0]_name":            "server",
+				"server[0]_host[0]_name":    "host",
+				"server[0]_host[0]_value":   "localhost",
+			},
+		},
+	} {
+		out := in.MapWith(c.options)
+		if !reflect.DeepEqual(out, c.out) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("expecting: %v", c.out)
+			t.Logf("having: %v", out)
+			t.Fail()
+		}
+
+		back, err := FromMapWith(out, c.options)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		expected := in
+		if c.options.OmitName {
+			expected.Name = ""
+		}
+		if !reflect.DeepEqual(back, expected) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having:\n\n%v\n", back)
+			t.Logf("expected:\n\n%v\n", expected)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package xmlx
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Mapping describes how the records of an XML source are turned into values,
+// so that a source can be associated to a configuration instead of a structure.
+//
+// A mapping reads as follows in YAML, types being "string", "int", "float",
+// "bool" or "time":
+//
+//	record: album
+//	split: songs
+//	fields:
+//	  - name: album
+//	    path: "@name"
+//	    required: true
+//	  - name: number
+//	    path: songs/@number
+//	    type: int
+//	    default: "0"
+type Mapping struct {
+
+	// The name of the record elements, as given to ChunkAll
+	Record string `json:"record" yaml:"record"`
+
+	// The label given to Split on each record, if any. Records having no subnode
+	// matching the label produce no output record.
+	Split string `json:"split,omitempty" yaml:"split,omitempty"`
+
+	// The minimal number of records per segment given to ChunkAll, 100 by default
+	BulkLen int `json:"bulk,omitempty" yaml:"bulk,omitempty"`
+
+	// The fields of the output records
+	Fields []Field `json:"fields" yaml:"fields"`
+}
+
+// Field describes a field of the records produced by a mapping.
+type Field struct {
+
+	// The name of the field
+	Name string `json:"name" yaml:"name"`
+
+	// The query expression selecting the value of the field, relative to each
+	// record. The first selected value is used. See Node.Query for the syntax.
+	Path string `json:"path" yaml:"path"`
+
+	// The type of the value
+	Type Type `json:"type,omitempty" yaml:"type,omitempty"`
+
+	// The value used when the path selects nothing
+	Default string `json:"default,omitempty" yaml:"default,omitempty"`
+
+	// Required fields must select a value or have a default
+	Required bool `json:"required,omitempty" yaml:"required,omitempty"`
+}
+
+// Record is a record produced by a mapping, holding the typed value of each field.
+// Fields that select nothing and have no default are left out.
+type Record map[string]interface{}
+
+// LoadMapping reads a mapping written in JSON or YAML. Unknown keys are rejected
+// in both formats.
+func LoadMapping(r io.Reader) (Mapping, error) {
+
+	var m Mapping
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return m, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.DisallowUnknownFields()
+		err = d.Decode(&m)
+	} else {
+		err = yaml.UnmarshalStrict(data, &m)
+	}
+	if err != nil {
+		return m, fmt.Errorf("invalid mapping: %s", err)
+	}
+
+	return m, nil
+}
+
+// Mapper applies a mapping to XML sources.
+type Mapper struct {
+	mapping Mapping
+	paths   []*Path
+}
+
+// NewMapper returns a new mapper applying the mapping. An error is returned if
+// the mapping has no record name, if a field has no name or a duplicated one,
+// or if a path cannot be compiled.
+func NewMapper(m Mapping) (*Mapper, error) {
+
+	if m.Record == "" {
+		return nil, fmt.Errorf("invalid mapping: missing record name")
+	}
+	if m.BulkLen <= 0 {
+		m.BulkLen = 100
+	}
+
+	names := map[string]bool{}
+	paths := make([]*Path, len(m.Fields))
+	for i, f := range m.Fields {
+		if f.Name == "" {
+			return nil, fmt.Errorf("invalid mapping: missing name of field %d", i+1)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("invalid mapping: duplicated field %q", f.Name)
+		}
+		names[f.Name] = true
+
+		p, err := CompilePath(f.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping: field %q: %s", f.Name, err)
+		}
+		paths[i] = p
+	}
+
+	return &Mapper{mapping: m, paths: paths}, nil
+}
+
+// Records returns the records of the node, which is a record element. The node
+// is split first if the mapping has a split label, each part giving a record.
+func (m *Mapper) Records(n Node) ([]Record, error) {
+
+	nodes := []Node{n}
+	if m.mapping.Split != "" {
+		nodes = n.Split(m.mapping.Split)
+	}
+
+	records := make([]Record, 0, len(nodes))
+	for _, node := range nodes {
+		r, err := m.record(node)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+// record returns the record of a single node.
+func (m *Mapper) record(n Node) (Record, error) {
+
+	r := make(Record, len(m.mapping.Fields))
+	for i, f := range m.mapping.Fields {
+		var value string
+		node, ok := m.paths[i].First(n)
+		switch {
+		case ok:
+			value = stringValue(&node)
+		case f.Default != "":
+			value = f.Default
+		case f.Required:
+			return nil, fmt.Errorf("missing required field %q", f.Name)
+		default:
+			continue
+		}
+
+		v, err := convert(value, f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for field %q: %s", f.Name, err)
+		}
+		r[f.Name] = v
+	}
+
+	return r, nil
+}
+
+// Apply chunks the reader with ChunkAll, decodes the record elements of each
+// segment in order, and calls fn with their records. It stops at the first error,
+// including the ones returned by fn.
+//
+// Record elements are decoded on their own: the namespace prefixes declared by
+// their ancestors are unknown, so paths should match such names by local name.
+func (m *Mapper) Apply(reader io.ReadSeeker, fn func(Record) error) error {
+
+	segments, err := ChunkAll(reader, m.mapping.Record, m.mapping.BulkLen)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		err := decodeSegment(reader, s, m.mapping.Record, func(n Node) error {
+			records, err := m.Records(n)
+			if err != nil {
+				return err
+			}
+			for _, r := range records {
+				err := fn(r)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeSegment decodes the elements named after the token found in the segment
+// of the reader, and calls fn with each of them. Elements nested in a decoded one
+// are part of it, and are not decoded on their own.
+func decodeSegment(reader io.ReadSeeker, segment [2]int64, token string, fn func(Node) error) error {
+
+	_, err := reader.Seek(segment[0], io.SeekStart)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, segment[1]-segment[0])
+	_, err = io.ReadFull(reader, data)
+	if err != nil {
+		return err
+	}
+
+	// Tokens are read raw between the elements, since the segment may close
+	// elements it does not open. Each element is then decoded on its own.
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var pos, last int64
+	for {
+		t, err := decoder.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		elt, ok := t.(xml.StartElement)
+		if !ok || elt.Name.Local != token {
+			last = decoder.InputOffset()
+			continue
+		}
+
+		pos += last
+		var node Node
+		element := xml.NewDecoder(bytes.NewReader(data[pos:]))
+		err = element.Decode(&node)
+		if err != nil {
+			return err
+		}
+		err = fn(node)
+		if err != nil {
+			return err
+		}
+
+		pos += element.InputOffset()
+		decoder = xml.NewDecoder(bytes.NewReader(data[pos:]))
+		last = 0
+	}
+}
@@ -0,0 +1,194 @@
+package xmlx
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mappingYAML = `
+record: album
+split: songs
+bulk: 1
+fields:
+  - name: album
+    path: "@name"
+    required: true
+  - name: band
+    path: "meta/band"
+    default: unknown
+  - name: year
+    path: "meta/year"
+    type: int
+  - name: song
+    path: songs/name
+  - name: number
+    path: songs/@number
+    type: int
+    default: "0"
+  - name: single
+    path: songs/@single
+    type: bool
+    default: "false"
+`
+
+const mappingJSON = `{
+	"record": "album",
+	"split": "songs",
+	"bulk": 1,
+	"fields": [
+		{"name": "album", "path": "@name", "required": true},
+		{"name": "band", "path": "meta/band", "default": "unknown"},
+		{"name": "year", "path": "meta/year", "type": "int"},
+		{"name": "song", "path": "songs/name"},
+		{"name": "number", "path": "songs/@number", "type": "int", "default": "0"},
+		{"name": "single", "path": "songs/@single", "type": "bool", "default": "false"}
+	]
+}`
+
+func Test_LoadMapping(t *testing.T) {
+
+	y, err := LoadMapping(strings.NewReader(mappingYAML))
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	j, err := LoadMapping(strings.NewReader(mappingJSON))
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	if !reflect.DeepEqual(y, j) {
+		t.Logf("having: %v", y)
+		t.Logf("expected: %v", j)
+		t.Fail()
+	}
+
+	if y.Fields[2].Type != IntType || y.Fields[5].Type != BoolType || !y.Fields[0].Required {
+		t.Logf("having: %v", y.Fields)
+		t.Fail()
+	}
+
+	for _, in := range []string{
+		"record: album\nfields:\n  - name: x\n    type: integer\n",
+		"record: album\nunknown: true\n",
+		`{"record": "album", "fields": [{"name": "x", "type": "date"}]}`,
+		"record: album\nfields:\n  - name: x\n    requried: true\n",
+		`{"record": "album", "fields": [{"name": "x", "requried": true}]}`,
+	} {
+		_, err := LoadMapping(strings.NewReader(in))
+		if err == nil {
+			t.Logf("expected an error for %q", in)
+			t.Fail()
+		}
+	}
+}
+
+func Test_MapperApply(t *testing.T) {
+
+	m, err := LoadMapping(strings.NewReader(mappingYAML))
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	mapper, err := NewMapper(m)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	var having []Record
+	err = mapper.Apply(strings.NewReader(queryInput), func(r Record) error {
+		having = append(having, r)
+		return nil
+	})
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	expected := []Record{
+		{"album": "Black Album", "band": "Metallica", "year": int64(1991), "song": "Enter Sandman", "number": int64(1), "single": false},
+		{"album": "Black Album", "band": "Metallica", "year": int64(1991), "song": "Sad but True", "number": int64(2), "single": false},
+		{"album": "Black Album", "band": "Metallica", "year": int64(1991), "song": "The Unforgiven", "number": int64(3), "single": true},
+		{"album": "Load", "band": "unknown", "song": "Ain't My Bitch", "number": int64(1), "single": false},
+		{"album": "Load", "band": "unknown", "song": "2 X 4", "number": int64(2), "single": true},
+	}
+	if !reflect.DeepEqual(having, expected) {
+		t.Logf("having: %v", having)
+		t.Logf("expected: %v", expected)
+		t.Fail()
+	}
+}
+
+func Test_MapperRecords(t *testing.T) {
+
+	mapper, err := NewMapper(Mapping{
+		Record: "event",
+		Fields: []Field{
+			{Name: "id", Path: "@id", Type: IntType, Required: true},
+			{Name: "at", Path: "at", Type: TimeType},
+			{Name: "note", Path: "note"},
+		},
+	})
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	for i, c := range []struct {
+		in  string
+		out []Record
+		err bool
+	}{
+		{
+			in:  `<event id="4"><at>2019-03-01T10:00:00Z</at></event>`,
+			out: []Record{{"id": int64(4), "at": time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)}},
+		},
+		{in: `<event><at>2019-03-01T10:00:00Z</at></event>`, err: true},
+		{in: `<event id="four"/>`, err: true},
+		{in: `<event id="4"><at>yesterday</at></event>`, err: true},
+	} {
+		var node Node
+		err := xml.Unmarshal([]byte(c.in), &node)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		having, err := mapper.Records(node)
+		if (err != nil) != c.err {
+			t.Logf("failed case %d: unexpected error %v", i+1, err)
+			t.Fail()
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(having, c.out) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having: %v", having)
+			t.Logf("expected: %v", c.out)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NewMapperInvalid(t *testing.T) {
+
+	for i, m := range []Mapping{
+		{Fields: []Field{{Name: "x", Path: "x"}}},
+		{Record: "r", Fields: []Field{{Path: "x"}}},
+		{Record: "r", Fields: []Field{{Name: "x", Path: "x"}, {Name: "x", Path: "y"}}},
+		{Record: "r", Fields: []Field{{Name: "x", Path: "x["}}},
+	} {
+		_, err := NewMapper(m)
+		if err == nil {
+			t.Logf("failed case %d: expected an error", i+1)
+			t.Fail()
+		}
+	}
+}
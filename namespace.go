@@ -0,0 +1,103 @@
+package xmlx
+
+import (
+	"sort"
+	"strings"
+)
+
+// xmlSpace is the namespace URI bound to the reserved "xml" prefix.
+const xmlSpace = "http://www.w3.org/XML/1998/namespace"
+
+// binding associates a namespace prefix to a namespace URI.
+type binding struct {
+	prefix string
+	uri    string
+}
+
+// scope is the list of namespace bindings visible from a node. The innermost
+// bindings are located at the end of the list.
+type scope []binding
+
+// with returns the scope visible from within the given node.
+func (s scope) with(n Node) scope {
+
+	var prefixes []string
+	for p := range n.Namespaces {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	for _, p := range prefixes {
+		s = append(s, binding{prefix: p, uri: n.Namespaces[p]})
+	}
+
+	return s
+}
+
+// prefix returns the innermost prefix bound to the uri.
+func (s scope) prefix(uri string) (string, bool) {
+	if uri == xmlSpace {
+		return "xml", true
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].uri == uri {
+			return s[i].prefix, true
+		}
+	}
+	return "", false
+}
+
+// uri returns the uri bound to the prefix.
+func (s scope) uri(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return xmlSpace, true
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i].prefix == prefix {
+			return s[i].uri, true
+		}
+	}
+	return "", false
+}
+
+// splitName splits a name written as "local", "prefix:local" or "{uri}local"
+// into its parts. Only one of prefix and uri can be non empty.
+func splitName(name string) (uri, prefix, local string) {
+
+	if strings.HasPrefix(name, "{") {
+		if i := strings.Index(name, "}"); i > 0 {
+			return name[1:i], "", name[i+1:]
+		}
+	}
+
+	if i := strings.Index(name, ":"); i > 0 {
+		return "", name[:i], name[i+1:]
+	}
+
+	return "", "", name
+}
+
+// splitLabel splits the label around each dot located outside of curly braces,
+// so that namespace URIs can be used within the label.
+func splitLabel(label string) []string {
+
+	var terms []string
+	var depth, last int
+	for i, r := range label {
+		switch r {
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case '.':
+			if depth == 0 {
+				terms = append(terms, label[last:i])
+				last = i + 1
+			}
+		}
+	}
+
+	return append(terms, label[last:])
+}
@@ -5,45 +5,102 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
 // Node is a generic XML node.
 type Node struct {
 
-	// The name of the node
+	// The local name of the node
 	Name string
 
-	// The attribute list of the node
+	// The namespace URI of the node
+	Space string
+
+	// The namespace prefix of the node, as written in the document
+	Prefix string
+
+	// The attribute list of the node. Attributes belonging to a namespace are
+	// keyed by "{uri}local", the others by their local name.
 	Attrs map[string]string
 
-	// The data located within the node
+	// The namespace prefixes of the attributes belonging to a namespace, as
+	// written in the document, keyed as in Attrs. They resolve the prefixes
+	// declared by the ancestors of the node.
+	AttrPrefixes map[string]string
+
+	// The namespace declarations of the node, from prefix to URI. The default
+	// namespace is declared with an empty prefix.
+	Namespaces map[string]string
+
+	// The data located within the node. When the node contains several text
+	// segments, Data is their concatenation.
 	Data string
 
 	// The subnodes within the node
 	Nodes []Node
 
-	prefix string
+	// The ordered content of the node. It is only set when Data and Nodes cannot
+	// describe the node by themselves, such as mixed content, and its element
+	// segments refer, in order, to the entries of Nodes.
+	Content []Content
 }
 
 // UnmarshalXML takes the content of an XML node and puts it into the Node structure.
 func (n *Node) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	return n.unmarshal(d, start, nil)
+}
 
-	if len(start.Attr) != 0 {
-		n.Attrs = map[string]string{}
-		for _, v := range start.Attr {
-			n.Attrs[v.Name.Local] = v.Value
+// unmarshal decodes the node started by start. The scope contains the namespace
+// bindings declared by the ancestors of the node, and is used to retrieve the
+// prefixes the decoder translated into URIs.
+func (n *Node) unmarshal(d *xml.Decoder, start xml.StartElement, s scope) error {
+
+	for _, v := range start.Attr {
+		switch {
+		case v.Name.Space == "xmlns":
+			n.declare(v.Name.Local, v.Value)
+		case v.Name.Space == "" && v.Name.Local == "xmlns":
+			n.declare("", v.Value)
 		}
 	}
+	s = s.with(*n)
+
+	for _, v := range start.Attr {
+		if v.Name.Space == "xmlns" || v.Name.Space == "" && v.Name.Local == "xmlns" {
+			continue
+		}
+		if n.Attrs == nil {
+			n.Attrs = map[string]string{}
+		}
+		name := v.Name.Local
+		if v.Name.Space != "" {
+			name = "{" + v.Name.Space + "}" + name
+			if prefix, ok := s.prefix(v.Name.Space); ok && prefix != "" && v.Name.Space != xmlSpace {
+				n.setAttrPrefix(name, prefix)
+			}
+		}
+		n.Attrs[name] = v.Value
+	}
+
 	n.Name = start.Name.Local
+	n.Space = start.Name.Space
+	if n.Space != "" {
+		n.Prefix, _ = s.prefix(n.Space)
+	}
 
-	balance := 1
+	var content []Content
+	defer func() { n.setContent(content) }()
 
-	for balance != 0 {
+	// The decoder guarantees that the start and end elements are properly nested
+	// and matched: the first end element encountered at this level closes the node,
+	// and every start element, whatever its name, opens a subnode.
+	for {
 		token, err := d.Token()
 		if err != nil {
 			if err == io.EOF {
-				break
+				return nil
 			}
 			return err
 		}
@@ -51,38 +108,240 @@ func (n *Node) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 		switch t := token.(type) {
 
 		case xml.CharData:
-			v := bytes.TrimSpace(t)
-			if len(v) == 0 {
-				continue
-			}
-			n.Data = string(t)
+			content = append(content, Content{Kind: TextContent, Data: string(t)})
 
-		case xml.StartElement:
-			if t.Name.Local == start.Name.Local {
-				balance++
-				continue
-			}
+		case xml.Comment:
+			content = append(content, Content{Kind: CommentContent, Data: string(t)})
+
+		case xml.ProcInst:
+			content = append(content, Content{Kind: ProcInstContent, Target: t.Target, Data: string(t.Inst)})
 
+		case xml.StartElement:
 			node := Node{}
-			err := node.UnmarshalXML(d, t)
+			err := node.unmarshal(d, t, s)
 			if err != nil {
 				return err
 			}
 
 			n.Nodes = append(n.Nodes, node)
+			content = append(content, Content{Kind: ElementContent})
 
 		case xml.EndElement:
-			if t.Name.Local == start.Name.Local {
-				balance--
+			return nil
+		}
+	}
+}
+
+// declare adds a namespace declaration to the node.
+func (n *Node) declare(prefix, uri string) {
+	if n.Namespaces == nil {
+		n.Namespaces = map[string]string{}
+	}
+	n.Namespaces[prefix] = uri
+}
+
+// setAttrPrefix records the prefix of the attribute.
+func (n *Node) setAttrPrefix(key, prefix string) {
+	if n.AttrPrefixes == nil {
+		n.AttrPrefixes = map[string]string{}
+	}
+	n.AttrPrefixes[key] = prefix
+}
+
+// space returns the namespace URI bound to the prefix, as declared by the node,
+// used by its name, or used by one of its attributes.
+func (n Node) space(prefix string) (string, bool) {
+	if uri, ok := (scope{{prefix: n.Prefix, uri: n.Space}}).with(n).uri(prefix); ok {
+		return uri, true
+	}
+	for k, p := range n.AttrPrefixes {
+		if p == prefix {
+			uri, _, _ := splitName(k)
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// attrName returns the name of the attribute qualified with the prefix bound to
+// its namespace in the scope, or with the prefix it was written with.
+func (n Node) attrName(key string, s scope) string {
+	uri, _, local := splitName(key)
+	if uri == "" {
+		return key
+	}
+	if p, ok := s.prefix(uri); ok && p != "" {
+		return p + ":" + local
+	}
+	if p, ok := n.AttrPrefixes[key]; ok {
+		if u, taken := s.uri(p); !taken || u == uri {
+			return p + ":" + local
+		}
+	}
+	return key
+}
+
+// MarshalXML writes the node, its attributes, data and subnodes into the encoder.
+// The name of the node is used as element name; the start element is only used
+// when the node has no name. Namespace declarations come first, then the
+// attributes sorted by name. Use the Indent method of the encoder to indent the output:
+// nodes having a Content are written without indentation, so that their text is kept.
+func (n Node) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if n.Name == "" {
+		n.Name, n.Space, n.Prefix = start.Name.Local, start.Name.Space, ""
+	}
+	return n.marshal(e, nil)
+}
+
+// marshal encodes the node. The scope contains the namespace bindings declared
+// by the ancestors of the node.
+func (n Node) marshal(e *xml.Encoder, s scope) error {
+
+	var decls, attrs []xml.Attr
+	declare := func(prefix, uri string) {
+		name := "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		decls = append(decls, xml.Attr{Name: xml.Name{Local: name}, Value: uri})
+		s = append(s, binding{prefix: prefix, uri: uri})
+	}
+
+	// Write the declarations of the node, then the ones required by the node
+	// and its attributes that are not in the scope yet.
+	var prefixes []string
+	for p := range n.Namespaces {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	for _, p := range prefixes {
+		declare(p, n.Namespaces[p])
+	}
+
+	if uri, _ := s.uri(n.Prefix); uri != n.Space {
+		declare(n.Prefix, n.Space)
+	}
+
+	var names []string
+	for k := range n.Attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		uri, prefix, local := splitName(k)
+		if uri != "" {
+			var ok bool
+			prefix, ok = s.prefix(uri)
+			if p := n.AttrPrefixes[k]; (!ok || prefix == "") && p != "" {
+				if _, taken := s.uri(p); !taken {
+					declare(p, uri)
+					prefix, ok = p, true
+				}
 			}
+			for i := len(s); !ok || prefix == ""; i++ {
+				prefix = fmt.Sprintf("ns%d", i)
+				if _, taken := s.uri(prefix); !taken {
+					declare(prefix, uri)
+					ok = true
+				}
+			}
+			local = prefix + ":" + local
+		} else if prefix != "" {
+			local = k
+		}
+		attrs = append(attrs, xml.Attr{Name: xml.Name{Local: local}, Value: n.Attrs[k]})
+	}
+
+	name := xml.Name{Local: n.qname()}
+	start := xml.StartElement{Name: name, Attr: append(decls, attrs...)}
+
+	// The content is written without indentation, which would change its text.
+	if n.Content != nil {
+		var b bytes.Buffer
+		inner := xml.NewEncoder(&b)
+		err := n.marshalContent(inner, s)
+		if err == nil {
+			err = inner.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		return e.EncodeElement(struct {
+			Inner []byte `xml:",innerxml"`
+		}{b.Bytes()}, start)
+	}
+
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	if len(n.Data) != 0 {
+		err := e.EncodeToken(xml.CharData(n.Data))
+		if err != nil {
+			return err
 		}
 	}
 
-	return nil
+	for _, node := range n.Nodes {
+		err := node.marshal(e, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(xml.EndElement{Name: name})
+}
+
+// Attr returns the value of the attribute having the given name. The name can be
+// written as "local", "{uri}local" or "prefix:local", where the prefix is one
+// declared by the node itself, the prefix of the node, or the prefix an attribute
+// was written with.
+func (n Node) Attr(name string) (string, bool) {
+
+	uri, prefix, local := splitName(name)
+	if prefix != "" {
+		var ok bool
+		uri, ok = n.space(prefix)
+		if !ok {
+			return "", false
+		}
+	}
+	if uri != "" {
+		local = "{" + uri + "}" + local
+	}
+
+	v, ok := n.Attrs[local]
+	return v, ok
+}
+
+// is returns true if the node matches the given name. The name can be written as
+// "local", which matches any namespace, "{uri}local" or "prefix:local".
+func (n Node) is(name string) bool {
+	uri, prefix, local := splitName(name)
+	switch {
+	case n.Name != local:
+		return false
+	case uri != "":
+		return n.Space == uri
+	case prefix != "":
+		return n.Prefix == prefix
+	}
+	return true
+}
+
+// qname returns the name of the node qualified with its prefix, if any.
+func (n Node) qname() string {
+	if n.Prefix == "" {
+		return n.Name
+	}
+	return n.Prefix + ":" + n.Name
 }
 
 // Split the node into many: each time the split label is encountered within a subnode of the node,
-// a new node is created.
+// a new node is created. Each term of the label is matched as described in Attr, and
+// terms are separated by dots.
 func (n Node) Split(label string) []Node {
 
 	// Return the node itself if no label is specified: there is no split to do.
@@ -91,34 +350,40 @@ func (n Node) Split(label string) []Node {
 	}
 
 	// Create a leveled array of children.
-	terms := strings.Split(label, ".")
+	terms := splitLabel(label)
 	gen := len(terms)
 	children := make([][]Node, gen+1, gen+1)
 	children[0] = n.Nodes
 
 	// Explore the leveled array. On each level, put the children of the matching node
-	// to the upper level.
+	// to the upper level. The children of the last level are renamed after their parent.
 	for i, term := range terms {
 		for _, node := range children[i] {
-			if node.Name == term {
-				children[i+1] = append(children[i+1], node.Nodes...)
+			if !node.is(term) {
+				continue
+			}
+			for _, child := range node.Nodes {
+				if i == gen-1 {
+					child.Name, child.Space, child.Prefix = node.Name, node.Space, node.Prefix
+				}
+				children[i+1] = append(children[i+1], child)
 			}
 		}
 	}
 
-	// Create a node for each child. Also rename the child with label.
+	// Create a node for each child.
 	var nodes []Node
 	for _, child := range children[gen] {
 		node := n.clone()
 		var i int
 		for i < len(node.Nodes) {
-			if node.Nodes[i].Name == terms[0] {
-				node.Nodes = append(node.Nodes[:i], node.Nodes[i+1:]...)
+			if node.Nodes[i].is(terms[0]) {
+				node.removeNode(i)
+				continue
 			}
 			i++
 		}
-		child.Name = terms[gen-1]
-		node.Nodes = append(node.Nodes, child)
+		node.appendNode(child)
 		nodes = append(nodes, node)
 	}
 
@@ -126,52 +391,81 @@ func (n Node) Split(label string) []Node {
 }
 
 // Map returns a flatten representation of the node. If a node contains nodes
-// having the same name, only the last node will exist in the map.
+// having the same name, only the last node will exist in the map: use MapIndexed
+// to keep all of them.
+//
+// Names are qualified with their prefix when they belong to a namespace, so
+// "a:id" and "b:id" produce distinct keys. Attributes whose namespace is not
+// bound to any prefix are keyed by "{uri}local".
+//
+// Use MapWith to change the syntax of the keys.
 func (n Node) Map() map[string]string {
+	return n.MapWith(MapOptions{})
+}
 
-	out := n.flatten()
+// MapIndexed returns a flatten representation of the node, as Map does, except
+// that the name of each subnode is followed by its index among the siblings
+// having the same name, such as "#nodes.song[3].#data". Indices start at 0 and
+// follow the order of Nodes.
+func (n Node) MapIndexed() map[string]string {
+	return n.MapWith(MapOptions{Indexed: true})
+}
 
-	var toProcess []Node
-	for _, node := range n.Nodes {
-		node.prefix = fmt.Sprintf("#nodes.%s", node.Name)
-		toProcess = append(toProcess, node)
+// MapWith returns a flatten representation of the node, as Map does, with keys
+// following the given options.
+func (n Node) MapWith(o MapOptions) map[string]string {
+	out := map[string]string{}
+	n.mapInto(out, "", o.withDefaults(), nil)
+	return out
+}
+
+// mapInto puts the flatten representation of the node in out, each key being
+// prefixed by key.
+func (n Node) mapInto(out map[string]string, key string, o MapOptions, s scope) {
+
+	s = s.with(n)
+	if n.Space != "" {
+		s = append(s, binding{prefix: n.Prefix, uri: n.Space})
 	}
 
-	var i int
-	for i < len(toProcess) {
-		node := toProcess[i]
-		for k, v := range node.flatten() {
-			name := fmt.Sprintf("%s.%s", node.prefix, k)
-			out[name] = v
+	for k, v := range n.flatten(o, s) {
+		if k == o.DataKey && o.OmitDataKey && key != "" {
+			out[strings.TrimSuffix(key, o.Separator)] = v
+			continue
 		}
+		out[key+k] = v
+	}
 
-		for _, child := range node.Nodes {
-			child.prefix = fmt.Sprintf("%s.#nodes.%s", node.prefix, child.Name)
-			toProcess = append(toProcess, child)
+	indices := map[string]int{}
+	for _, child := range n.Nodes {
+		name := o.escape(child.qname())
+		if o.Indexed {
+			name = fmt.Sprintf("%s[%d]", name, indices[child.qname()])
+			indices[child.qname()]++
+		}
+		if !o.OmitNodePrefix {
+			name = o.NodePrefix + name
 		}
-		i++
+		child.mapInto(out, key+name+o.Separator, o, s)
 	}
-
-	return out
 }
 
 // flatten takes a node a generates a map with it.
-func (n Node) flatten() map[string]string {
+func (n Node) flatten(o MapOptions, s scope) map[string]string {
 
 	var t = map[string]string{}
 
 	// put simple values into transcient.
-	if len(n.Name) != 0 {
-		t["#name"] = n.Name
+	if len(n.Name) != 0 && !o.OmitName {
+		t[o.NameKey] = n.qname()
 	}
 	if len(n.Data) != 0 {
-		t["#data"] = n.Data
+		t[o.DataKey] = n.Data
 	}
 
 	// put attributes into transcient.
 	for k, v := range n.Attrs {
-		name := fmt.Sprintf("#attr.%s", k)
-		t[name] = v
+		t[o.AttrPrefix+o.escape(n.attrName(k, s))] = v
 	}
 
 	return t
@@ -181,10 +475,16 @@ func (n Node) flatten() map[string]string {
 func (n Node) clone() Node {
 
 	node := Node{
-		Name:   n.Name,
-		Attrs:  n.Attrs,
-		Data:   n.Data,
-		prefix: n.prefix,
+		Name:         n.Name,
+		Space:        n.Space,
+		Prefix:       n.Prefix,
+		Attrs:        n.Attrs,
+		AttrPrefixes: n.AttrPrefixes,
+		Namespaces:   n.Namespaces,
+		Data:         n.Data,
+	}
+	if n.Content != nil {
+		node.Content = append([]Content{}, n.Content...)
 	}
 	for i := range n.Nodes {
 		node.Nodes = append(node.Nodes, n.Nodes[i].clone())
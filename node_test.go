@@ -7,31 +7,30 @@ import (
 	"testing"
 )
 
-func Test_NodeUnmarshalXML(t *testing.T) {
-
-	for i, c := range []struct {
-		input    string
-		expected interface{}
-	}{
-		{
-			input: `
+// nodeCases are XML inputs along with the node they unmarshal into.
+var nodeCases = []struct {
+	input    string
+	expected interface{}
+}{
+	{
+		input: `
             <foo>
                 <bar/>
             </foo>
 			`,
-			expected: Node{
-				Name:  "foo",
-				Attrs: nil,
-				Nodes: []Node{
-					{
-						Name:  "bar",
-						Attrs: nil,
-					},
+		expected: Node{
+			Name:  "foo",
+			Attrs: nil,
+			Nodes: []Node{
+				{
+					Name:  "bar",
+					Attrs: nil,
 				},
 			},
 		},
-		{
-			input: `
+	},
+	{
+		input: `
 <music>
 	<album name="Black Album">
 		<meta>
@@ -41,24 +40,23 @@ func Test_NodeUnmarshalXML(t *testing.T) {
 	</album>
 </music>
 			`,
-			expected: Node{
-				Name:  "music",
-				Nodes: []Node{
-					{
-						Name: "album",
-						Attrs: map[string]string{"name": "Black Album"},
-						Nodes: []Node{
-							{
-								Name:  "meta",
-								Nodes: []Node{
-									{
-										Name: "band",
-										Data: "Metallica",
-									},
-									{
-										Name: "year",
-										Data: "1991",
-									},
+		expected: Node{
+			Name: "music",
+			Nodes: []Node{
+				{
+					Name:  "album",
+					Attrs: map[string]string{"name": "Black Album"},
+					Nodes: []Node{
+						{
+							Name: "meta",
+							Nodes: []Node{
+								{
+									Name: "band",
+									Data: "Metallica",
+								},
+								{
+									Name: "year",
+									Data: "1991",
 								},
 							},
 						},
@@ -66,8 +64,9 @@ func Test_NodeUnmarshalXML(t *testing.T) {
 				},
 			},
 		},
-		{
-			input: `
+	},
+	{
+		input: `
 <?xml version="1.0"?>
 <music>
 	<album name="Black Album">
@@ -108,120 +107,119 @@ func Test_NodeUnmarshalXML(t *testing.T) {
 	</songs>
 </music>
 			`,
-			expected: Node{
-				Name:  "music",
-				Nodes: []Node{
-					{
-						Name: "album",
-						Attrs: map[string]string{"name": "Black Album"},
-						Nodes: []Node{
-							{
-								Name:  "meta",
-								Nodes: []Node{
-									{
-										Name: "band",
-										Data: "Metallica",
-									},
-									{
-										Name: "year",
-										Data: "1991",
-									},
+		expected: Node{
+			Name: "music",
+			Nodes: []Node{
+				{
+					Name:  "album",
+					Attrs: map[string]string{"name": "Black Album"},
+					Nodes: []Node{
+						{
+							Name: "meta",
+							Nodes: []Node{
+								{
+									Name: "band",
+									Data: "Metallica",
+								},
+								{
+									Name: "year",
+									Data: "1991",
 								},
 							},
 						},
 					},
-					{
-						Name: "songs",
-						Nodes: []Node{
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "Enter Sandman",
-									},
-									{
-										Name: "number",
-										Data: "1",
-									},
+				},
+				{
+					Name: "songs",
+					Nodes: []Node{
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "Enter Sandman",
+								},
+								{
+									Name: "number",
+									Data: "1",
 								},
 							},
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "Sad but True",
-									},
-									{
-										Name: "number",
-										Data: "2",
-									},
+						},
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "Sad but True",
+								},
+								{
+									Name: "number",
+									Data: "2",
 								},
 							},
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "Holier Than You",
-									},
-									{
-										Name: "number",
-										Data: "3",
-									},
+						},
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "Holier Than You",
+								},
+								{
+									Name: "number",
+									Data: "3",
 								},
 							},
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "The Unforgiven",
-									},
-									{
-										Name: "number",
-										Data: "4",
-									},
+						},
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "The Unforgiven",
+								},
+								{
+									Name: "number",
+									Data: "4",
 								},
 							},
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "Wherever I May Roam",
-									},
-									{
-										Name: "number",
-										Data: "5",
-									},
+						},
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "Wherever I May Roam",
+								},
+								{
+									Name: "number",
+									Data: "5",
 								},
 							},
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "Don't Tread on Me",
-									},
-									{
-										Name: "number",
-										Data: "6",
-									},
+						},
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "Don't Tread on Me",
+								},
+								{
+									Name: "number",
+									Data: "6",
 								},
 							},
-							{
-								Name: "song",
-								Nodes: []Node{
-									{
-										Name: "name",
-										Data: "Through the Never",
-									},
-									{
-										Name: "number",
-										Data: "7",
-									},
+						},
+						{
+							Name: "song",
+							Nodes: []Node{
+								{
+									Name: "name",
+									Data: "Through the Never",
+								},
+								{
+									Name: "number",
+									Data: "7",
 								},
 							},
 						},
@@ -229,7 +227,82 @@ func Test_NodeUnmarshalXML(t *testing.T) {
 				},
 			},
 		},
-	} {
+	},
+	{
+		input: `<item><item>x</item></item>`,
+		expected: Node{
+			Name: "item",
+			Nodes: []Node{
+				{
+					Name: "item",
+					Data: "x",
+				},
+			},
+		},
+	},
+	{
+		input: `
+<categories>
+	<category name="music">
+		<category name="rock">
+			<category name="metal"/>
+		</category>
+		<category name="jazz"/>
+	</category>
+</categories>
+		`,
+		expected: Node{
+			Name: "categories",
+			Nodes: []Node{
+				{
+					Name:  "category",
+					Attrs: map[string]string{"name": "music"},
+					Nodes: []Node{
+						{
+							Name:  "category",
+							Attrs: map[string]string{"name": "rock"},
+							Nodes: []Node{
+								{
+									Name:  "category",
+									Attrs: map[string]string{"name": "metal"},
+								},
+							},
+						},
+						{
+							Name:  "category",
+							Attrs: map[string]string{"name": "jazz"},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		input: `<div><div><div>deep</div></div><div>sibling</div></div>`,
+		expected: Node{
+			Name: "div",
+			Nodes: []Node{
+				{
+					Name: "div",
+					Nodes: []Node{
+						{
+							Name: "div",
+							Data: "deep",
+						},
+					},
+				},
+				{
+					Name: "div",
+					Data: "sibling",
+				},
+			},
+		},
+	},
+}
+
+func Test_NodeUnmarshalXML(t *testing.T) {
+
+	for i, c := range nodeCases {
 
 		having := Node{}
 		err := xml.Unmarshal([]byte(c.input), &having)
@@ -240,8 +313,8 @@ func Test_NodeUnmarshalXML(t *testing.T) {
 
 		if !reflect.DeepEqual(having, c.expected) {
 			t.Logf("failed case %d", i+1)
-			t.Logf("having:\n\n%s\n", having)
-			t.Logf("expected:\n\n%s\n", c.expected)
+			t.Logf("having:\n\n%v\n", having)
+			t.Logf("expected:\n\n%v\n", c.expected)
 			t.Fail()
 		}
 	}
@@ -336,7 +409,7 @@ func Test_NodeMap(t *testing.T) {
 				},
 			},
 			out: map[string]string{
-				"#name":               "Paris",
+				"#name":      "Paris",
 				"#attr.type": "city",
 
 				"#nodes.foo.#name": "foo",
@@ -386,14 +459,538 @@ func ExampleNode() {
 	if err != nil {
 		// do stuff...
 	}
-	
+
 	fmt.Println(node)
 	for _, n := range node.Split("album.songs") {
 		fmt.Println(n)
 	}
-	
+
 	// Output:
-	// {music map[]  [{album map[]  [{songs map[]  [{song map[]  [{name map[] Don't Tread on Me [] } {number map[] 6 [] }] } {song map[]  [{name map[] Through the Never [] } {number map[] 7 [] }] }] }] }] }
-	// {music map[]  [{songs map[]  [{name map[] Don't Tread on Me [] } {number map[] 6 [] }] }] }
-	// {music map[]  [{songs map[]  [{name map[] Through the Never [] } {number map[] 7 [] }] }] }
+	// {music   map[] map[] map[]  [{album   map[] map[] map[]  [{songs   map[] map[] map[]  [{song   map[] map[] map[]  [{name   map[] map[] map[] Don't Tread on Me [] []} {number   map[] map[] map[] 6 [] []}] []} {song   map[] map[] map[]  [{name   map[] map[] map[] Through the Never [] []} {number   map[] map[] map[] 7 [] []}] []}] []}] []}] []}
+	// {music   map[] map[] map[]  [{songs   map[] map[] map[]  [{name   map[] map[] map[] Don't Tread on Me [] []} {number   map[] map[] map[] 6 [] []}] []}] []}
+	// {music   map[] map[] map[]  [{songs   map[] map[] map[]  [{name   map[] map[] map[] Through the Never [] []} {number   map[] map[] map[] 7 [] []}] []}] []}
+}
+
+func Test_NodeUnmarshalXMLNamespace(t *testing.T) {
+
+	input := `
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:a="urn:a" xmlns:b="urn:b">
+	<entry a:id="1" b:id="2" xml:lang="en">
+		<a:id>first</a:id>
+		<b:id>second</b:id>
+	</entry>
+</feed>
+	`
+
+	expected := Node{
+		Name:  "feed",
+		Space: "http://www.w3.org/2005/Atom",
+		Namespaces: map[string]string{
+			"":  "http://www.w3.org/2005/Atom",
+			"a": "urn:a",
+			"b": "urn:b",
+		},
+		Nodes: []Node{
+			{
+				Name:  "entry",
+				Space: "http://www.w3.org/2005/Atom",
+				Attrs: map[string]string{
+					"{urn:a}id": "1",
+					"{urn:b}id": "2",
+					"{http://www.w3.org/XML/1998/namespace}lang": "en",
+				},
+				AttrPrefixes: map[string]string{
+					"{urn:a}id": "a",
+					"{urn:b}id": "b",
+				},
+				Nodes: []Node{
+					{
+						Name:   "id",
+						Space:  "urn:a",
+						Prefix: "a",
+						Data:   "first",
+					},
+					{
+						Name:   "id",
+						Space:  "urn:b",
+						Prefix: "b",
+						Data:   "second",
+					},
+				},
+			},
+		},
+	}
+
+	having := Node{}
+	err := xml.Unmarshal([]byte(input), &having)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	if !reflect.DeepEqual(having, expected) {
+		t.Logf("having:\n\n%v\n", having)
+		t.Logf("expected:\n\n%v\n", expected)
+		t.Fail()
+	}
+
+	out := having.Map()
+	for k, v := range map[string]string{
+		"#nodes.entry.#attr.a:id":        "1",
+		"#nodes.entry.#attr.b:id":        "2",
+		"#nodes.entry.#attr.xml:lang":    "en",
+		"#nodes.entry.#nodes.a:id.#data": "first",
+		"#nodes.entry.#nodes.b:id.#data": "second",
+		"#nodes.entry.#nodes.b:id.#name": "b:id",
+	} {
+		if out[k] != v {
+			t.Logf("map key %s: expected %q, having %q", k, v, out[k])
+			t.Fail()
+		}
+	}
+
+	for label, expected := range map[string]int{
+		"entry":                              2,
+		"{http://www.w3.org/2005/Atom}entry": 2,
+		"{urn:a}entry":                       0,
+		"a:entry":                            0,
+	} {
+		nodes := having.Split(label)
+		if len(nodes) != expected {
+			t.Log("on label", label)
+			t.Logf("having: %v", nodes)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NodeAttr(t *testing.T) {
+
+	node := Node{
+		Name:       "entry",
+		Space:      "urn:c",
+		Prefix:     "c",
+		Namespaces: map[string]string{"a": "urn:a"},
+		Attrs: map[string]string{
+			"id":        "0",
+			"{urn:a}id": "1",
+			"{urn:c}id": "3",
+		},
+	}
+
+	for i, c := range []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{name: "id", value: "0", ok: true},
+		{name: "a:id", value: "1", ok: true},
+		{name: "{urn:a}id", value: "1", ok: true},
+		{name: "c:id", value: "3", ok: true},
+		{name: "b:id", ok: false},
+		{name: "{urn:b}id", ok: false},
+	} {
+		value, ok := node.Attr(c.name)
+		if value != c.value || ok != c.ok {
+			t.Logf("failed case %d: %s", i+1, c.name)
+			t.Logf("having: %q %v", value, ok)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NodeAncestorAttrPrefix(t *testing.T) {
+
+	var feed Node
+	err := xml.Unmarshal([]byte(`<feed xmlns:a="urn:a"><entry a:id="1"><a:id>x</a:id></entry></feed>`), &feed)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+	entry := feed.Nodes[0]
+
+	if value, ok := entry.Attr("a:id"); value != "1" || !ok {
+		t.Logf("attr: having %q %v", value, ok)
+		t.Fail()
+	}
+
+	nodes, err := feed.Query("//entry/@a:id")
+	if err != nil || len(nodes) != 1 || nodes[0].Data != "1" {
+		t.Logf("query: having %v %v", nodes, err)
+		t.Fail()
+	}
+
+	var v struct {
+		ID string `xmlx:"@a:id"`
+	}
+	err = entry.Decode(&v)
+	if err != nil || v.ID != "1" {
+		t.Logf("decode: having %q %v", v.ID, err)
+		t.Fail()
+	}
+
+	if out := entry.Map(); out["#attr.a:id"] != "1" {
+		t.Logf("map: having %v", out)
+		t.Fail()
+	}
+
+	out, err := xml.Marshal(entry)
+	expected := `<entry xmlns:a="urn:a" a:id="1"><a:id>x</a:id></entry>`
+	if err != nil || string(out) != expected {
+		t.Logf("marshal: having %s %v", out, err)
+		t.Fail()
+	}
+}
+
+func Test_NodeMarshalXML(t *testing.T) {
+
+	for i, c := range []struct {
+		in     Node
+		indent bool
+		out    string
+	}{
+		{
+			in: Node{
+				Name:  "foo",
+				Attrs: map[string]string{"z": "1", "a": `"<&>"`},
+				Data:  "Tom & Jerry <3",
+			},
+			out: `<foo a="&#34;&lt;&amp;&gt;&#34;" z="1">Tom &amp; Jerry &lt;3</foo>`,
+		},
+		{
+			in: Node{
+				Name:       "feed",
+				Space:      "http://www.w3.org/2005/Atom",
+				Namespaces: map[string]string{"": "http://www.w3.org/2005/Atom"},
+				Nodes: []Node{
+					{
+						Name:   "id",
+						Space:  "urn:a",
+						Prefix: "a",
+						Attrs:  map[string]string{"{urn:b}id": "2", "{urn:a}id": "1"},
+					},
+				},
+			},
+			out: `<feed xmlns="http://www.w3.org/2005/Atom"><a:id xmlns:a="urn:a" xmlns:ns2="urn:b" a:id="1" ns2:id="2"></a:id></feed>`,
+		},
+		{
+			in: Node{
+				Name: "music",
+				Nodes: []Node{
+					{
+						Name: "band",
+						Data: "Metallica",
+					},
+					{
+						Name: "year",
+						Data: "1991",
+					},
+				},
+			},
+			indent: true,
+			out: `<music>
+  <band>Metallica</band>
+  <year>1991</year>
+</music>`,
+		},
+	} {
+		var out []byte
+		var err error
+		if c.indent {
+			out, err = xml.MarshalIndent(c.in, "", "  ")
+		} else {
+			out, err = xml.Marshal(c.in)
+		}
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+		}
+
+		if string(out) != c.out {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having:\n%s", out)
+			t.Logf("expected:\n%s", c.out)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NodeMarshalXMLRoundTrip(t *testing.T) {
+
+	inputs := []string{
+		`<feed xmlns="http://www.w3.org/2005/Atom" xmlns:a="urn:a"><entry a:id="1" xml:lang="en"><a:id>first</a:id><id/></entry></feed>`,
+		`<foo a="&#34;&lt;&amp;&gt;&#34;">Tom &amp; Jerry &lt;3</foo>`,
+		`<item><p>Hello <b>big</b> world<!-- c --></p><q xmlns:a="urn:a"><a:b>x</a:b> y</q></item>`,
+	}
+	for _, c := range nodeCases {
+		inputs = append(inputs, c.input)
+	}
+
+	for i, input := range inputs {
+		var first, second Node
+		err := xml.Unmarshal([]byte(input), &first)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		for _, indent := range []string{"", "\t"} {
+			out, err := xml.MarshalIndent(first, "", indent)
+			if err != nil {
+				t.Logf("failed case %d: %s", i+1, err)
+				t.Fail()
+				continue
+			}
+
+			err = xml.Unmarshal(out, &second)
+			if err != nil {
+				t.Logf("failed case %d: %s", i+1, err)
+				t.Fail()
+				continue
+			}
+
+			if !reflect.DeepEqual(first, second) {
+				t.Logf("failed case %d", i+1)
+				t.Logf("having:\n\n%v\n", second)
+				t.Logf("expected:\n\n%v\n", first)
+				t.Fail()
+			}
+			second = Node{}
+		}
+	}
+}
+
+func Test_NodeUnmarshalXMLMixedContent(t *testing.T) {
+
+	for i, c := range []struct {
+		input    string
+		expected Node
+	}{
+		{
+			input: `<p>Hello <b>big</b> world</p>`,
+			expected: Node{
+				Name: "p",
+				Data: "Hello  world",
+				Nodes: []Node{
+					{
+						Name: "b",
+						Data: "big",
+					},
+				},
+				Content: []Content{
+					{Kind: TextContent, Data: "Hello "},
+					{Kind: ElementContent},
+					{Kind: TextContent, Data: " world"},
+				},
+			},
+		},
+		{
+			input: `<p><!-- note --><?php echo 1 ?>text<![CDATA[<raw>]]></p>`,
+			expected: Node{
+				Name: "p",
+				Data: "text<raw>",
+				Content: []Content{
+					{Kind: CommentContent, Data: " note "},
+					{Kind: ProcInstContent, Target: "php", Data: "echo 1 "},
+					{Kind: TextContent, Data: "text"},
+					{Kind: TextContent, Data: "<raw>"},
+				},
+			},
+		},
+		{
+			input: `<description>&lt;p&gt;Hello&lt;/p&gt;</description>`,
+			expected: Node{
+				Name: "description",
+				Data: "<p>Hello</p>",
+			},
+		},
+		{
+			input: `<a>&amp;&amp;&amp;</a>`,
+			expected: Node{
+				Name: "a",
+				Data: "&&&",
+			},
+		},
+		{
+			input: `<a><![CDATA[<raw>]]></a>`,
+			expected: Node{
+				Name: "a",
+				Data: "<raw>",
+			},
+		},
+		{
+			input: "<a>\n  text\n  <b/>\n</a>",
+			expected: Node{
+				Name:  "a",
+				Data:  "\n  text\n  ",
+				Nodes: []Node{{Name: "b"}},
+			},
+		},
+		{
+			input: `<a><b/>tail</a>`,
+			expected: Node{
+				Name:  "a",
+				Data:  "tail",
+				Nodes: []Node{{Name: "b"}},
+				Content: []Content{
+					{Kind: ElementContent},
+					{Kind: TextContent, Data: "tail"},
+				},
+			},
+		},
+	} {
+		having := Node{}
+		err := xml.Unmarshal([]byte(c.input), &having)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+		}
+
+		if !reflect.DeepEqual(having, c.expected) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("having:\n\n%v\n", having)
+			t.Logf("expected:\n\n%v\n", c.expected)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NodeMixedContent(t *testing.T) {
+
+	input := `<item><title>A <em>fine</em> day</title><desc>Hello <b>big</b> world</desc><desc>Bye</desc></item>`
+
+	var node Node
+	err := xml.Unmarshal([]byte(input), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	// The mixed content must survive a marshal.
+	out, err := xml.Marshal(node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+	if string(out) != input {
+		t.Logf("having:\n%s", out)
+		t.Logf("expected:\n%s", input)
+		t.Fail()
+	}
+
+	// CDATA segments are written as CDATA sections.
+	for _, c := range []struct {
+		data string
+		out  string
+	}{
+		{data: "<raw> & <b>", out: `<p>text<![CDATA[<raw> & <b>]]></p>`},
+		{data: "", out: `<p>text<![CDATA[]]></p>`},
+		{data: "a ]]> b", out: `<p>texta ]]&gt; b</p>`},
+	} {
+		node = Node{
+			Name: "p",
+			Data: "text" + c.data,
+			Content: []Content{
+				{Kind: TextContent, Data: "text"},
+				{Kind: CDATAContent, Data: c.data},
+			},
+		}
+		out, err = xml.Marshal(node)
+		if err != nil || string(out) != c.out {
+			t.Logf("having:\n%s %v", out, err)
+			t.Logf("expected:\n%s", c.out)
+			t.Fail()
+		}
+	}
+
+	// An edited Data replaces the text of the content.
+	node = Node{}
+	err = xml.Unmarshal([]byte(`<p>Hello <b>big</b> world<!-- c --></p>`), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+	node.Data = "changed"
+	out, err = xml.Marshal(node)
+	if expected := `<p>changed<b>big</b><!-- c --></p>`; err != nil || string(out) != expected {
+		t.Logf("having:\n%s %v", out, err)
+		t.Logf("expected:\n%s", expected)
+		t.Fail()
+	}
+
+	// The mixed content must survive a split.
+	input = `<album>Best of <songs><song>One</song><song>Two</song></songs> by <band>X</band></album>`
+	node = Node{}
+	err = xml.Unmarshal([]byte(input), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	expected := []string{
+		`<album>Best of  by <band>X</band><songs>One</songs></album>`,
+		`<album>Best of  by <band>X</band><songs>Two</songs></album>`,
+	}
+	var having []string
+	for _, n := range node.Split("songs") {
+		out, err := xml.Marshal(n)
+		if err != nil {
+			t.Log("unexpected error", err)
+			t.FailNow()
+		}
+		having = append(having, string(out))
+	}
+
+	if !reflect.DeepEqual(having, expected) {
+		t.Logf("having:\n%s", having)
+		t.Logf("expected:\n%s", expected)
+		t.Fail()
+	}
+}
+
+func Test_NodeMapIndexed(t *testing.T) {
+
+	in := Node{
+		Name: "songs",
+		Nodes: []Node{
+			{
+				Name: "song",
+				Data: "Enter Sandman",
+			},
+			{
+				Name: "song",
+				Data: "Sad but True",
+				Nodes: []Node{
+					{
+						Name:  "tag",
+						Attrs: map[string]string{"name": "single"},
+					},
+				},
+			},
+			{
+				Name: "count",
+				Data: "2",
+			},
+		},
+	}
+
+	out := map[string]string{
+		"#name":                                   "songs",
+		"#nodes.song[0].#name":                    "song",
+		"#nodes.song[0].#data":                    "Enter Sandman",
+		"#nodes.song[1].#name":                    "song",
+		"#nodes.song[1].#data":                    "Sad but True",
+		"#nodes.song[1].#nodes.tag[0].#name":      "tag",
+		"#nodes.song[1].#nodes.tag[0].#attr.name": "single",
+		"#nodes.count[0].#name":                   "count",
+		"#nodes.count[0].#data":                   "2",
+	}
+
+	having := in.MapIndexed()
+	if !reflect.DeepEqual(having, out) {
+		t.Logf("expecting: %v", out)
+		t.Logf("having: %v", having)
+		t.Fail()
+	}
 }
@@ -0,0 +1,178 @@
+package xmlx
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// ProcessParallel chunks the reader with ChunkAll, then decodes the segments
+// concurrently and calls fn with each element named after the token. Elements
+// are delivered as soon as they are decoded, so fn is called concurrently and
+// in no particular order. Use ProcessParallelOrdered to receive them in document order.
+//
+// The reader must have a Size or a Stat method, as *bytes.Reader, *io.SectionReader
+// and *os.File do. The number of workers defaults to the number of CPUs.
+// Elements are decoded on their own, as Mapper.Apply does.
+//
+// Processing stops on the first error, including the ones returned by fn, and
+// this error is returned once every worker is done.
+func ProcessParallel(r io.ReaderAt, token string, bulkLen, workers int, fn func(Node) error) error {
+	return processParallel(r, token, bulkLen, workers, false, fn)
+}
+
+// ProcessParallelOrdered works as ProcessParallel, except that fn is called from
+// a single goroutine, with the elements in document order. Decoded segments wait
+// for the previous ones to be delivered, so at most twice the number of workers
+// segments are held in memory.
+func ProcessParallelOrdered(r io.ReaderAt, token string, bulkLen, workers int, fn func(Node) error) error {
+	return processParallel(r, token, bulkLen, workers, true, fn)
+}
+
+// parallelJob is a segment to decode. In ordered mode, the decoded nodes are
+// sent to the result channel instead of being delivered by the worker.
+type parallelJob struct {
+	segment [2]int64
+	result  chan parallelResult
+}
+
+// parallelResult holds the nodes of a decoded segment.
+type parallelResult struct {
+	nodes []Node
+	err   error
+}
+
+// processParallel implements ProcessParallel and ProcessParallelOrdered.
+func processParallel(r io.ReaderAt, token string, bulkLen, workers int, ordered bool, fn func(Node) error) error {
+
+	size, err := readerSize(r)
+	if err != nil {
+		return err
+	}
+
+	segments, err := ChunkAll(io.NewSectionReader(r, 0, size), token, bulkLen)
+	if err != nil {
+		return err
+	}
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	// The first error closes done, which stops the dispatch and the workers.
+	var first error
+	var once sync.Once
+	done := make(chan struct{})
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			close(done)
+		})
+	}
+	stopped := func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// The pending channel holds the results in the order of the segments, and
+	// bounds the number of segments decoded ahead of the delivery.
+	jobs := make(chan parallelJob)
+	pending := make(chan chan parallelResult, workers)
+	go func() {
+		defer close(jobs)
+		defer close(pending)
+		for _, s := range segments {
+			job := parallelJob{segment: s}
+			if ordered {
+				job.result = make(chan parallelResult, 1)
+				select {
+				case pending <- job.result:
+				case <-done:
+					return
+				}
+			}
+			select {
+			case jobs <- job:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader := io.NewSectionReader(r, 0, size)
+			for job := range jobs {
+				var nodes []Node
+				err := decodeSegment(reader, job.segment, token, func(n Node) error {
+					if stopped() {
+						return errStopped
+					}
+					if ordered {
+						nodes = append(nodes, n)
+						return nil
+					}
+					return fn(n)
+				})
+				if err != nil && err != errStopped {
+					fail(err)
+				}
+				if job.result != nil {
+					job.result <- parallelResult{nodes: nodes, err: err}
+				}
+			}
+		}()
+	}
+
+	if ordered {
+	deliver:
+		for result := range pending {
+			var res parallelResult
+			select {
+			case res = <-result:
+			case <-done:
+				break deliver
+			}
+			if res.err != nil {
+				break deliver
+			}
+			for _, n := range res.nodes {
+				err := fn(n)
+				if err != nil {
+					fail(err)
+					break deliver
+				}
+			}
+		}
+		fail(nil)
+	}
+
+	wg.Wait()
+	return first
+}
+
+// readerSize returns the size of the reader, if it has a Size or a Stat method.
+func readerSize(r io.ReaderAt) (int64, error) {
+
+	switch v := r.(type) {
+	case interface{ Size() int64 }:
+		return v.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := v.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	}
+
+	return 0, fmt.Errorf("cannot get the size of %T", r)
+}
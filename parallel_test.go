@@ -0,0 +1,128 @@
+package xmlx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// parallelInput returns a document made of n numbered song elements.
+func parallelInput(n int) string {
+	var b strings.Builder
+	b.WriteString("<music><songs>")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `<song number="%d"><name>Song %d</name></song>`, i, i)
+		if i%7 == 0 {
+			b.WriteString("</songs><songs>")
+		}
+	}
+	b.WriteString("</songs></music>")
+	return b.String()
+}
+
+func Test_ProcessParallel(t *testing.T) {
+
+	input := parallelInput(500)
+	for i, c := range []struct {
+		bulkLen int
+		workers int
+		ordered bool
+	}{
+		{bulkLen: 10, workers: 4, ordered: true},
+		{bulkLen: 10, workers: 4},
+		{bulkLen: 1, workers: 0, ordered: true},
+		{bulkLen: 1000, workers: 1},
+	} {
+		var mu sync.Mutex
+		var numbers []string
+		fn := func(n Node) error {
+			mu.Lock()
+			defer mu.Unlock()
+			numbers = append(numbers, n.Attrs["number"])
+			return nil
+		}
+
+		var err error
+		if c.ordered {
+			err = ProcessParallelOrdered(strings.NewReader(input), "song", c.bulkLen, c.workers, fn)
+		} else {
+			err = ProcessParallel(strings.NewReader(input), "song", c.bulkLen, c.workers, fn)
+		}
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		if !c.ordered {
+			sort.Slice(numbers, func(i, j int) bool {
+				return len(numbers[i]) < len(numbers[j]) || len(numbers[i]) == len(numbers[j]) && numbers[i] < numbers[j]
+			})
+		}
+		for j, number := range numbers {
+			if number != fmt.Sprint(j+1) {
+				t.Logf("failed case %d: having %q at position %d", i+1, number, j)
+				t.Fail()
+				break
+			}
+		}
+		if len(numbers) != 500 {
+			t.Logf("failed case %d: having %d elements", i+1, len(numbers))
+			t.Fail()
+		}
+	}
+}
+
+func Test_ProcessParallelError(t *testing.T) {
+
+	input := parallelInput(500)
+	expected := errors.New("boom")
+	for _, ordered := range []bool{true, false} {
+		var mu sync.Mutex
+		var count int
+		fn := func(n Node) error {
+			mu.Lock()
+			defer mu.Unlock()
+			count++
+			if n.Attrs["number"] == "42" {
+				return expected
+			}
+			return nil
+		}
+
+		process := ProcessParallel
+		if ordered {
+			process = ProcessParallelOrdered
+		}
+
+		err := process(strings.NewReader(input), "song", 5, 4, fn)
+		if err != expected {
+			t.Logf("ordered %v: having error %v", ordered, err)
+			t.Fail()
+		}
+		if ordered && count != 42 || count == 500 {
+			t.Logf("ordered %v: having %d calls", ordered, count)
+			t.Fail()
+		}
+	}
+
+	// Invalid segments stop the processing too.
+	invalid := strings.Replace(input, `<song number="300">`, `<song number="300"><b>`, 1)
+	err := ProcessParallelOrdered(strings.NewReader(invalid), "song", 5, 4, func(Node) error { return nil })
+	if err == nil {
+		t.Log("expected an error for an invalid segment")
+		t.Fail()
+	}
+
+	var r io.ReaderAt = struct{ io.ReaderAt }{bytes.NewReader([]byte(input))}
+	err = ProcessParallel(r, "song", 5, 4, func(Node) error { return nil })
+	if err == nil {
+		t.Log("expected an error for a reader without size")
+		t.Fail()
+	}
+}
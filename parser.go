@@ -0,0 +1,402 @@
+package xmlx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tokenKind is the kind of a token of an expression.
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokSlash
+	tokDoubleSlash
+	tokLBracket
+	tokRBracket
+	tokLParen
+	tokRParen
+	tokAt
+	tokComma
+	tokStar
+	tokDot
+	tokDoubleDot
+	tokAxis
+	tokOp
+	tokString
+	tokNumber
+	tokName
+)
+
+// token is a token of an expression.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits the expression into tokens.
+func lex(s string) ([]token, error) {
+
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		start := i
+		emit := func(kind tokenKind, end int) {
+			tokens = append(tokens, token{kind: kind, text: s[start:end], pos: start})
+			i = end
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case strings.HasPrefix(s[i:], "//"):
+			emit(tokDoubleSlash, i+2)
+		case c == '/':
+			emit(tokSlash, i+1)
+		case c == '[':
+			emit(tokLBracket, i+1)
+		case c == ']':
+			emit(tokRBracket, i+1)
+		case c == '(':
+			emit(tokLParen, i+1)
+		case c == ')':
+			emit(tokRParen, i+1)
+		case c == '@':
+			emit(tokAt, i+1)
+		case c == ',':
+			emit(tokComma, i+1)
+		case c == '*':
+			emit(tokStar, i+1)
+		case strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			emit(tokOp, i+2)
+		case c == '=' || c == '<' || c == '>':
+			emit(tokOp, i+1)
+
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, token{kind: tokString, text: s[i+1 : i+1+end], pos: i})
+			i += end + 2
+
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			end := i
+			for end < len(s) && (s[end] >= '0' && s[end] <= '9' || s[end] == '.') {
+				end++
+			}
+			emit(tokNumber, end)
+
+		case strings.HasPrefix(s[i:], ".."):
+			emit(tokDoubleDot, i+2)
+		case c == '.':
+			emit(tokDot, i+1)
+
+		case c == '{' || isNameStart(c):
+			end := i
+			if c == '{' {
+				brace := strings.IndexByte(s[i:], '}')
+				if brace < 0 {
+					return nil, fmt.Errorf("unterminated namespace at offset %d", i)
+				}
+				end += brace + 1
+			} else {
+				end = scanName(s, end)
+				if end+1 < len(s) && s[end] == ':' && s[end+1] != ':' {
+					end++
+				}
+			}
+			end = scanName(s, end)
+			if strings.HasPrefix(s[end:], "::") {
+				tokens = append(tokens, token{kind: tokAxis, text: s[start:end], pos: start})
+				i = end + 2
+				continue
+			}
+			emit(tokName, end)
+
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, i)
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(s)}), nil
+}
+
+// isNameStart returns true if the byte can start a name.
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+// scanName returns the offset of the first byte after the name starting at i.
+func scanName(s string, i int) int {
+	for i < len(s) && (isNameStart(s[i]) || s[i] >= '0' && s[i] <= '9' || s[i] == '-' || s[i] == '.') {
+		i++
+	}
+	return i
+}
+
+// parser builds expressions from a list of tokens.
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// peek returns the current token.
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// next returns the current token and moves to the next one.
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// expect consumes the current token if it has the given kind.
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind {
+		return fmt.Errorf("expected %q at offset %d", text, t.pos)
+	}
+	return nil
+}
+
+// parsePath parses a location path.
+func (p *parser) parsePath() (*locationPath, error) {
+
+	path := &locationPath{}
+	switch p.peek().kind {
+	case tokSlash:
+		p.next()
+		path.absolute = true
+		if !p.startsStep() {
+			return path, nil
+		}
+	case tokDoubleSlash:
+		p.next()
+		path.absolute = true
+		path.steps = append(path.steps, step{axis: descendantOrSelfAxis, test: nodeTest})
+	}
+
+	for {
+		s, err := p.parseStep()
+		if err != nil {
+			return nil, err
+		}
+		path.steps = append(path.steps, s)
+
+		switch p.peek().kind {
+		case tokSlash:
+			p.next()
+		case tokDoubleSlash:
+			p.next()
+			path.steps = append(path.steps, step{axis: descendantOrSelfAxis, test: nodeTest})
+		default:
+			return path, nil
+		}
+	}
+}
+
+// startsStep returns true if the current token can start a step.
+func (p *parser) startsStep() bool {
+	switch p.peek().kind {
+	case tokName, tokStar, tokAt, tokDot, tokDoubleDot, tokAxis:
+		return true
+	}
+	return false
+}
+
+// parseStep parses a step of a location path.
+func (p *parser) parseStep() (step, error) {
+
+	s := step{axis: childAxis}
+	t := p.next()
+
+	switch t.kind {
+	case tokDot:
+		s.axis, s.test = selfAxis, nodeTest
+		return s, nil
+	case tokDoubleDot:
+		return s, fmt.Errorf("parent steps are not supported at offset %d", t.pos)
+	case tokAt:
+		s.axis = attributeAxis
+		t = p.next()
+	case tokAxis:
+		a, ok := axes[t.text]
+		if !ok {
+			return s, fmt.Errorf("unsupported axis %q at offset %d", t.text, t.pos)
+		}
+		s.axis = a
+		t = p.next()
+	}
+
+	switch {
+	case t.kind == tokStar:
+		s.test = anyTest
+	case t.kind == tokName && (t.text == "text" || t.text == "node") && p.peek().kind == tokLParen:
+		p.next()
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return s, err
+		}
+		s.test = textTest
+		if t.text == "node" {
+			s.test = nodeTest
+		}
+	case t.kind == tokName:
+		s.test, s.name = nameTest, t.text
+	default:
+		return s, fmt.Errorf("expected a name at offset %d", t.pos)
+	}
+
+	for p.peek().kind == tokLBracket {
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return s, err
+		}
+		if err := p.expect(tokRBracket, "]"); err != nil {
+			return s, err
+		}
+		s.preds = append(s.preds, e)
+	}
+
+	return s, nil
+}
+
+// parseExpr parses an expression, made of "or" operations.
+func (p *parser) parseExpr() (expr, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokName && p.peek().text == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "or", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses an expression made of "and" operations.
+func (p *parser) parseAnd() (expr, error) {
+
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokName && p.peek().text == "and" {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: "and", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parseComparison parses a comparison, or a single primary expression.
+func (p *parser) parseComparison() (expr, error) {
+
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().kind == tokOp {
+		op := p.next().text
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+// parsePrimary parses a literal, a function call, a parenthesized expression
+// or a location path.
+func (p *parser) parsePrimary() (expr, error) {
+
+	t := p.peek()
+	switch t.kind {
+	case tokString:
+		p.next()
+		return literal{value: t.text}, nil
+
+	case tokNumber:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", t.text, t.pos)
+		}
+		return literal{value: f}, nil
+
+	case tokLParen:
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(tokRParen, ")")
+
+	case tokName:
+		if p.tokens[p.pos+1].kind == tokLParen && t.text != "text" && t.text != "node" {
+			return p.parseCall()
+		}
+	}
+
+	if t.kind == tokSlash || t.kind == tokDoubleSlash || p.startsStep() {
+		return p.parsePath()
+	}
+
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+// parseCall parses a function call.
+func (p *parser) parseCall() (expr, error) {
+
+	t := p.next()
+	arity, ok := functions[t.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", t.text, t.pos)
+	}
+	p.next()
+
+	c := call{name: t.text}
+	for p.peek().kind != tokRParen {
+		if len(c.args) != 0 {
+			if err := p.expect(tokComma, ","); err != nil {
+				return nil, err
+			}
+		}
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, e)
+	}
+	p.next()
+
+	if len(c.args) < arity[0] || arity[1] >= 0 && len(c.args) > arity[1] {
+		return nil, fmt.Errorf("wrong number of arguments for %s at offset %d", t.text, t.pos)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,55 @@
+package xmlx
+
+// Path is a compiled query expression, as described in Node.Query. A Path is
+// safe for concurrent use, and can be evaluated against many nodes without
+// parsing the expression again.
+type Path struct {
+	expr string
+	path *locationPath
+}
+
+// CompilePath parses the expression and returns a Path that can be evaluated
+// against nodes.
+func CompilePath(expr string) (*Path, error) {
+
+	p, err := compileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Path{expr: expr, path: p}, nil
+}
+
+// MustCompilePath is like CompilePath, but panics if the expression cannot be parsed.
+func MustCompilePath(expr string) *Path {
+	p, err := CompilePath(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source expression of the path.
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Select returns the nodes selected by the path, with n as context node.
+func (p *Path) Select(n Node) []Node {
+	return p.path.selectNodes(n)
+}
+
+// First returns the first node selected by the path. The boolean is false when
+// the path selects no node.
+func (p *Path) First(n Node) (Node, bool) {
+	nodes := p.path.selectNodes(n)
+	if len(nodes) == 0 {
+		return Node{}, false
+	}
+	return nodes[0], true
+}
+
+// Exists returns true if the path selects at least one node.
+func (p *Path) Exists(n Node) bool {
+	return len(p.path.selectNodes(n)) != 0
+}
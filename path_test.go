@@ -0,0 +1,97 @@
+package xmlx
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_Path(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(queryInput), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	for i, c := range []struct {
+		expr   string
+		count  int
+		first  string
+		exists bool
+	}{
+		{expr: "album/songs/song", count: 5, first: "1", exists: true},
+		{expr: "album[2]/songs/song", count: 2, first: "1", exists: true},
+		{expr: "//song[@single]", count: 2, first: "3", exists: true},
+		{expr: "//song[@number=4]", count: 0, exists: false},
+	} {
+		p, err := CompilePath(c.expr)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		if p.String() != c.expr {
+			t.Logf("failed case %d: having expression %q", i+1, p.String())
+			t.Fail()
+		}
+
+		if nodes := p.Select(node); len(nodes) != c.count {
+			t.Logf("failed case %d: having %d nodes", i+1, len(nodes))
+			t.Fail()
+		}
+
+		first, ok := p.First(node)
+		if ok != c.exists || first.Attrs["number"] != c.first {
+			t.Logf("failed case %d: having first %v %v", i+1, first, ok)
+			t.Fail()
+		}
+
+		if p.Exists(node) != c.exists {
+			t.Logf("failed case %d: having exists %v", i+1, !c.exists)
+			t.Fail()
+		}
+	}
+
+	_, err = CompilePath("album[")
+	if err == nil {
+		t.Log("expected an error")
+		t.Fail()
+	}
+}
+
+// benchmarkNode returns the node used by benchmarks.
+func benchmarkNode(b *testing.B) Node {
+	var node Node
+	err := xml.Unmarshal([]byte(queryInput), &node)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return node
+}
+
+func Benchmark_Split(b *testing.B) {
+	node := benchmarkNode(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		node.Split("album.songs")
+	}
+}
+
+func Benchmark_PathSelect(b *testing.B) {
+	node := benchmarkNode(b)
+	p := MustCompilePath("album/songs/song")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.Select(node)
+	}
+}
+
+func Benchmark_Query(b *testing.B) {
+	node := benchmarkNode(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		node.Query("album/songs/song")
+	}
+}
@@ -0,0 +1,574 @@
+package xmlx
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query returns the nodes selected by the expression, evaluated with the node as
+// context. The expression is written in a subset of XPath 1.0:
+//
+//   - location paths, absolute or relative, using the child, descendant,
+//     descendant-or-self, self and attribute axes, along with the "//", "@"
+//     and "." abbreviations;
+//   - name tests, written as described in Attr, "*", text() and node();
+//   - predicates comparing paths, strings and numbers with =, !=, <, <=, > and >=,
+//     combined with "and" and "or", and positional predicates such as [2] or
+//     [last()];
+//   - the functions last, position, count, not, true, false, string, concat,
+//     contains, starts-with, ends-with, normalize-space, string-length, name,
+//     local-name and number.
+//
+// An absolute path starts from a document containing the node, so "/music"
+// selects the node itself if it is named music. Attributes and texts are returned
+// as nodes holding their value in Data.
+//
+// Query compiles the expression on each call: use CompilePath to evaluate the same
+// expression against many nodes.
+func (n Node) Query(expr string) ([]Node, error) {
+
+	p, err := CompilePath(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Select(n), nil
+}
+
+// QueryOne returns the first node selected by the expression. The boolean is
+// false when the expression selects no node.
+func (n Node) QueryOne(expr string) (Node, bool, error) {
+
+	nodes, err := n.Query(expr)
+	if err != nil || len(nodes) == 0 {
+		return Node{}, false, err
+	}
+
+	return nodes[0], true, nil
+}
+
+// compileQuery parses the expression into a location path.
+func compileQuery(expr string) (*locationPath, error) {
+
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", expr, err)
+	}
+
+	p := &parser{tokens: tokens}
+	path, err := p.parsePath()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %q at offset %d", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %s", expr, err)
+	}
+
+	return path, nil
+}
+
+// selectNodes evaluates the path with n as context node.
+func (p *locationPath) selectNodes(n Node) []Node {
+
+	doc := &Node{Nodes: []Node{n}}
+	ctx := evalContext{item: item{node: &doc.Nodes[0]}, root: doc, position: 1, size: 1}
+
+	items := p.evalItems(ctx)
+	nodes := make([]Node, 0, len(items))
+	for _, it := range items {
+		nodes = append(nodes, it.toNode())
+	}
+
+	return nodes
+}
+
+// item is an element, an attribute or a text selected by an expression.
+type item struct {
+
+	// The element, or the element holding the attribute or the text
+	node *Node
+
+	// The key of the attribute in the Attrs of node, if the item is an attribute
+	attr string
+
+	// The index of the text, starting at 1, if the item is a text
+	text int
+}
+
+// value returns the string value of the item.
+func (it item) value() string {
+	switch {
+	case it.attr != "":
+		return it.node.Attrs[it.attr]
+	case it.text != 0 && it.node.Content != nil:
+		return it.node.Content[it.text-1].Data
+	case it.text != 0:
+		return it.node.Data
+	}
+	return stringValue(it.node)
+}
+
+// toNode converts the item into a node.
+func (it item) toNode() Node {
+	switch {
+	case it.attr != "":
+		uri, _, local := splitName(it.attr)
+		return Node{Name: local, Space: uri, Data: it.value()}
+	case it.text != 0:
+		return Node{Data: it.value()}
+	}
+	return *it.node
+}
+
+// stringValue returns the concatenation of the texts of the node and its descendants.
+func stringValue(n *Node) string {
+
+	if n.Content == nil {
+		s := n.Data
+		for i := range n.Nodes {
+			s += stringValue(&n.Nodes[i])
+		}
+		return s
+	}
+
+	var s string
+	var elements int
+	for _, c := range n.Content {
+		switch {
+		case c.Kind.text():
+			s += c.Data
+		case c.Kind == ElementContent && elements < len(n.Nodes):
+			s += stringValue(&n.Nodes[elements])
+			elements++
+		}
+	}
+	return s
+}
+
+// evalContext is the context in which an expression is evaluated.
+type evalContext struct {
+	item     item
+	root     *Node
+	position int
+	size     int
+}
+
+// expr is a compiled expression. Its value is either a []item, a string,
+// a float64 or a bool.
+type expr interface {
+	eval(ctx evalContext) interface{}
+}
+
+// axis is the direction in which a step selects items.
+type axis int
+
+const (
+	childAxis axis = iota
+	descendantAxis
+	descendantOrSelfAxis
+	selfAxis
+	attributeAxis
+)
+
+var axes = map[string]axis{
+	"child":              childAxis,
+	"descendant":         descendantAxis,
+	"descendant-or-self": descendantOrSelfAxis,
+	"self":               selfAxis,
+	"attribute":          attributeAxis,
+}
+
+// testKind is the kind of item a step selects.
+type testKind int
+
+const (
+	nameTest testKind = iota
+	anyTest
+	textTest
+	nodeTest
+)
+
+// step is a step of a location path.
+type step struct {
+	axis  axis
+	test  testKind
+	name  string
+	preds []expr
+}
+
+// locationPath is a list of steps, evaluated from the context item or the root.
+type locationPath struct {
+	absolute bool
+	steps    []step
+}
+
+func (p *locationPath) eval(ctx evalContext) interface{} {
+	return p.evalItems(ctx)
+}
+
+// evalItems returns the items selected by the path, without duplicates.
+func (p *locationPath) evalItems(ctx evalContext) []item {
+
+	items := []item{ctx.item}
+	if p.absolute {
+		items = []item{{node: ctx.root}}
+	}
+
+	for _, s := range p.steps {
+		var next []item
+		seen := map[item]bool{}
+		for _, it := range items {
+			for _, candidate := range s.eval(it, ctx.root) {
+				if !seen[candidate] {
+					seen[candidate] = true
+					next = append(next, candidate)
+				}
+			}
+		}
+		items = next
+	}
+
+	return items
+}
+
+// eval returns the items selected by the step from the given item.
+func (s step) eval(it item, root *Node) []item {
+
+	var candidates []item
+	if it.attr == "" && it.text == 0 {
+		candidates = s.collect(it.node, s.axis, nil)
+	}
+
+	for _, pred := range s.preds {
+		var kept []item
+		for i, c := range candidates {
+			ctx := evalContext{item: c, root: root, position: i + 1, size: len(candidates)}
+			v := pred.eval(ctx)
+			if f, ok := v.(float64); ok {
+				if f == float64(i+1) {
+					kept = append(kept, c)
+				}
+				continue
+			}
+			if toBool(v) {
+				kept = append(kept, c)
+			}
+		}
+		candidates = kept
+	}
+
+	return candidates
+}
+
+// collect appends to out the items matching the step test along the axis of n.
+func (s step) collect(n *Node, a axis, out []item) []item {
+
+	switch a {
+	case selfAxis:
+		if s.test == nodeTest || s.test == anyTest && n.Name != "" || s.test == nameTest && n.is(s.name) {
+			out = append(out, item{node: n})
+		}
+
+	case attributeAxis:
+		if s.test != nameTest && s.test != anyTest && s.test != nodeTest {
+			return out
+		}
+		var keys []string
+		for k := range n.Attrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if s.test == nameTest && !n.hasAttr(k, s.name) {
+				continue
+			}
+			out = append(out, item{node: n, attr: k})
+		}
+
+	case childAxis:
+		if s.test == textTest || s.test == nodeTest {
+			out = appendTexts(n, out)
+		}
+		if s.test == textTest {
+			return out
+		}
+		for i := range n.Nodes {
+			out = s.collect(&n.Nodes[i], selfAxis, out)
+		}
+
+	case descendantAxis:
+		if s.test == textTest || s.test == nodeTest {
+			out = appendTexts(n, out)
+		}
+		for i := range n.Nodes {
+			out = s.collect(&n.Nodes[i], selfAxis, out)
+			out = s.collect(&n.Nodes[i], descendantAxis, out)
+		}
+
+	case descendantOrSelfAxis:
+		out = s.collect(n, selfAxis, out)
+		out = s.collect(n, descendantAxis, out)
+	}
+
+	return out
+}
+
+// appendTexts appends the texts of n to out.
+func appendTexts(n *Node, out []item) []item {
+
+	if n.Content == nil {
+		if n.Data != "" {
+			out = append(out, item{node: n, text: 1})
+		}
+		return out
+	}
+
+	for i, c := range n.Content {
+		if c.Kind.text() {
+			out = append(out, item{node: n, text: i + 1})
+		}
+	}
+	return out
+}
+
+// hasAttr returns true if the attribute key of the node matches the name. The
+// name is matched as in the is method.
+func (n Node) hasAttr(key, name string) bool {
+
+	uri, prefix, local := splitName(name)
+	kuri, _, klocal := splitName(key)
+	switch {
+	case klocal != local:
+		return false
+	case uri != "":
+		return kuri == uri
+	case prefix != "":
+		uri, ok := n.space(prefix)
+		return ok && kuri == uri
+	}
+	return true
+}
+
+// literal is a string or number constant.
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(evalContext) interface{} {
+	return l.value
+}
+
+// binary is an operation between two expressions.
+type binary struct {
+	op          string
+	left, right expr
+}
+
+func (b binary) eval(ctx evalContext) interface{} {
+	switch b.op {
+	case "or":
+		return toBool(b.left.eval(ctx)) || toBool(b.right.eval(ctx))
+	case "and":
+		return toBool(b.left.eval(ctx)) && toBool(b.right.eval(ctx))
+	}
+	return compare(b.op, b.left.eval(ctx), b.right.eval(ctx))
+}
+
+// call is a function call.
+type call struct {
+	name string
+	args []expr
+}
+
+// functions lists the supported functions along with their minimal and maximal
+// number of arguments. A negative maximum means no limit.
+var functions = map[string][2]int{
+	"last":            {0, 0},
+	"position":        {0, 0},
+	"count":           {1, 1},
+	"not":             {1, 1},
+	"true":            {0, 0},
+	"false":           {0, 0},
+	"string":          {0, 1},
+	"concat":          {2, -1},
+	"contains":        {2, 2},
+	"starts-with":     {2, 2},
+	"ends-with":       {2, 2},
+	"normalize-space": {0, 1},
+	"string-length":   {0, 1},
+	"name":            {0, 1},
+	"local-name":      {0, 1},
+	"number":          {0, 1},
+}
+
+func (c call) eval(ctx evalContext) interface{} {
+
+	args := make([]interface{}, len(c.args))
+	for i, a := range c.args {
+		args[i] = a.eval(ctx)
+	}
+
+	// Functions taking an optional argument default to the context item.
+	if len(args) == 0 {
+		args = append(args, []item{ctx.item})
+	}
+
+	switch c.name {
+	case "last":
+		return float64(ctx.size)
+	case "position":
+		return float64(ctx.position)
+	case "count":
+		items, _ := args[0].([]item)
+		return float64(len(items))
+	case "not":
+		return !toBool(args[0])
+	case "true":
+		return true
+	case "false":
+		return false
+	case "string":
+		return toString(args[0])
+	case "concat":
+		var s string
+		for _, a := range args {
+			s += toString(a)
+		}
+		return s
+	case "contains":
+		return strings.Contains(toString(args[0]), toString(args[1]))
+	case "starts-with":
+		return strings.HasPrefix(toString(args[0]), toString(args[1]))
+	case "ends-with":
+		return strings.HasSuffix(toString(args[0]), toString(args[1]))
+	case "normalize-space":
+		return strings.Join(strings.Fields(toString(args[0])), " ")
+	case "string-length":
+		return float64(len([]rune(toString(args[0]))))
+	case "number":
+		return toNumber(args[0])
+	case "name", "local-name":
+		items, _ := args[0].([]item)
+		if len(items) == 0 {
+			return ""
+		}
+		n := items[0].toNode()
+		if c.name == "name" && items[0].attr == "" {
+			return n.qname()
+		}
+		return n.Name
+	}
+
+	return nil
+}
+
+// compare applies the comparison operator to the values, following the XPath
+// rules: items are compared by their string value, and a comparison involving
+// several items is true if it is true for any of them.
+func compare(op string, left, right interface{}) bool {
+
+	if items, ok := left.([]item); ok {
+		for _, it := range items {
+			if compare(op, it.value(), right) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if items, ok := right.([]item); ok {
+		for _, it := range items {
+			if compare(op, left, it.value()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch op {
+	case "=", "!=":
+		var equal bool
+		_, lb := left.(bool)
+		_, rb := right.(bool)
+		_, lf := left.(float64)
+		_, rf := right.(float64)
+		switch {
+		case lb || rb:
+			equal = toBool(left) == toBool(right)
+		case lf || rf:
+			equal = toNumber(left) == toNumber(right)
+		default:
+			equal = toString(left) == toString(right)
+		}
+		return equal == (op == "=")
+	}
+
+	l, r := toNumber(left), toNumber(right)
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+	return false
+}
+
+// toBool converts the value into a boolean.
+func toBool(v interface{}) bool {
+	switch t := v.(type) {
+	case []item:
+		return len(t) != 0
+	case string:
+		return len(t) != 0
+	case float64:
+		return t != 0 && !math.IsNaN(t)
+	case bool:
+		return t
+	}
+	return false
+}
+
+// toString converts the value into a string.
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case []item:
+		if len(t) == 0 {
+			return ""
+		}
+		return t[0].value()
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	return ""
+}
+
+// toNumber converts the value into a number. Values that are not numbers
+// are converted to NaN.
+func toNumber(v interface{}) float64 {
+	switch t := v.(type) {
+	case float64:
+		return t
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(toString(v)), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
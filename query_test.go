@@ -0,0 +1,129 @@
+package xmlx
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+const queryInput = `
+<music xmlns:m="urn:meta">
+	<album name="Black Album" type="studio">
+		<m:meta>
+			<band>Metallica</band>
+			<year>1991</year>
+		</m:meta>
+		<songs>
+			<song number="1"><name>Enter Sandman</name></song>
+			<song number="2"><name>Sad but True</name></song>
+			<song number="3" single="true"><name>The Unforgiven</name></song>
+		</songs>
+	</album>
+	<album name="Load" type="studio">
+		<songs>
+			<song number="1"><name>Ain't My Bitch</name></song>
+			<song number="2" single="true"><name>2 X 4</name></song>
+		</songs>
+	</album>
+	<album name="S&amp;M" type="live"/>
+	<p>Hello <b>big</b> world</p>
+</music>`
+
+func Test_NodeQuery(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(queryInput), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	for i, c := range []struct {
+		expr string
+		out  []string
+	}{
+		{expr: "/music/album/@name", out: []string{"Black Album", "Load", "S&M"}},
+		{expr: "album/@name", out: []string{"Black Album", "Load", "S&M"}},
+		{expr: "child::album[2]/attribute::name", out: []string{"Load"}},
+		{expr: "album[last()]/@name", out: []string{"S&M"}},
+		{expr: "album[@type='live']/@name", out: []string{"S&M"}},
+		{expr: "album[@type!='live'][position()=1]/@name", out: []string{"Black Album"}},
+		{expr: "//song[1]/name", out: []string{"Enter Sandman", "Ain't My Bitch"}},
+		{expr: "//song[@single='true']/name/text()", out: []string{"The Unforgiven", "2 X 4"}},
+		{expr: "//song[@number>=2 and @number<3]/name", out: []string{"Sad but True", "2 X 4"}},
+		{expr: "//name[contains(., 'The') or starts-with(text(), '2')]", out: []string{"The Unforgiven", "2 X 4"}},
+		{expr: "//album[songs/song/name='2 X 4']/@name", out: []string{"Load"}},
+		{expr: "//album[count(songs/song)=3]/@name", out: []string{"Black Album"}},
+		{expr: "//album[not(songs)]/@name", out: []string{"S&M"}},
+		{expr: "//m:meta/*", out: []string{"Metallica", "1991"}},
+		{expr: "//{urn:meta}meta/year", out: []string{"1991"}},
+		{expr: "descendant::meta/band", out: []string{"Metallica"}},
+		{expr: "/music/p/text()", out: []string{"Hello ", " world"}},
+		{expr: "/music/p", out: []string{"Hello big world"}},
+		{expr: "/album", out: nil},
+		{expr: "//album[1]/self::node()/@name", out: []string{"Black Album"}},
+		{expr: "//song[string-length(name) < 6]/name", out: []string{"2 X 4"}},
+	} {
+		nodes, err := node.Query(c.expr)
+		if err != nil {
+			t.Logf("failed case %d: %s", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		var out []string
+		for _, n := range nodes {
+			out = append(out, stringValue(&n))
+		}
+
+		if !reflect.DeepEqual(out, c.out) {
+			t.Logf("failed case %d: %s", i+1, c.expr)
+			t.Logf("having: %q", out)
+			t.Logf("expected: %q", c.out)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NodeQueryInvalid(t *testing.T) {
+
+	for _, expr := range []string{
+		"",
+		"album[",
+		"album[@name='x]",
+		"album/..",
+		"parent::album",
+		"album[unknown()]",
+		"album[contains(@name)]",
+		"album]",
+		"//",
+	} {
+		_, err := Node{}.Query(expr)
+		if err == nil {
+			t.Logf("expected an error for %q", expr)
+			t.Fail()
+		}
+	}
+}
+
+func Test_NodeQueryOne(t *testing.T) {
+
+	var node Node
+	err := xml.Unmarshal([]byte(queryInput), &node)
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	album, ok, err := node.QueryOne("album[@type='live']")
+	if err != nil || !ok || album.Attrs["name"] != "S&M" {
+		t.Logf("having: %v %v %v", album, ok, err)
+		t.Fail()
+	}
+
+	_, ok, err = node.QueryOne("album[@type='compilation']")
+	if err != nil || ok {
+		t.Logf("having: %v %v", ok, err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,85 @@
+package xmlx
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// CountRecords reads the reader once and returns the number of elements named
+// after the token. Elements nested in a matching element are not counted.
+func CountRecords(reader io.Reader, token string) (int, error) {
+
+	var count int
+	err := scanTokens(context.Background(), reader, token, func(start, stop int64) error {
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// RecordAt reads the reader from its start up to the nth element named after the
+// token, starting at 0, and returns this element along with its start and stop
+// offsets. The element is decoded on its own: the namespace prefixes declared by
+// its ancestors are unknown. Use an Index to access many elements.
+func RecordAt(reader io.ReadSeeker, token string, n int) (Node, [2]int64, error) {
+
+	if n < 0 {
+		return Node{}, [2]int64{}, fmt.Errorf("invalid record number %d", n)
+	}
+
+	_, err := reader.Seek(0, io.SeekStart)
+	if err != nil {
+		return Node{}, [2]int64{}, err
+	}
+
+	var count int
+	var segment [2]int64
+	err = scanTokens(context.Background(), reader, token, func(start, stop int64) error {
+		if count == n {
+			segment = [2]int64{start, stop}
+			return errStopped
+		}
+		count++
+		return nil
+	})
+	switch err {
+	case errStopped:
+	case nil:
+		return Node{}, [2]int64{}, fmt.Errorf("invalid record number %d: the reader holds %d records", n, count)
+	default:
+		return Node{}, [2]int64{}, err
+	}
+
+	node, err := decodeRecord(reader, segment)
+	return node, segment, err
+}
+
+// RecordAt returns the nth element of the indexed source, starting at 0, along
+// with its start and stop offsets. Only the element is read from the reader,
+// which must be the indexed source: see Matches and Verify.
+func (x *Index) RecordAt(reader io.ReadSeeker, n int) (Node, [2]int64, error) {
+
+	segment, err := x.Offset(n)
+	if err != nil {
+		return Node{}, [2]int64{}, err
+	}
+
+	node, err := decodeRecord(reader, segment)
+	return node, segment, err
+}
+
+// decodeRecord decodes the element found in the segment of the reader.
+func decodeRecord(reader io.ReadSeeker, segment [2]int64) (Node, error) {
+
+	_, err := reader.Seek(segment[0], io.SeekStart)
+	if err != nil {
+		return Node{}, err
+	}
+
+	var node Node
+	err = xml.NewDecoder(io.LimitReader(reader, segment[1]-segment[0])).Decode(&node)
+	return node, err
+}
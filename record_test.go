@@ -0,0 +1,78 @@
+package xmlx
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_RecordAt(t *testing.T) {
+
+	input := parallelInput(100)
+	x, err := BuildIndex(strings.NewReader(input), "song")
+	if err != nil {
+		t.Log("unexpected error", err)
+		t.FailNow()
+	}
+
+	r := strings.NewReader(input)
+	for _, n := range []int{0, 1, 41, 99} {
+		node, segment, err := RecordAt(r, "song", n)
+		if err != nil {
+			t.Logf("record %d: %s", n, err)
+			t.Fail()
+			continue
+		}
+
+		indexed, indexedSegment, err := x.RecordAt(r, n)
+		if err != nil {
+			t.Logf("record %d: %s", n, err)
+			t.Fail()
+			continue
+		}
+
+		number := node.Attrs["number"]
+		if number != indexed.Attrs["number"] || segment != indexedSegment || segment != x.Offsets[n] {
+			t.Logf("record %d: having %v %v and %v %v", n, node, segment, indexed, indexedSegment)
+			t.Fail()
+		}
+		if number != strconv.Itoa(n+1) || node.Nodes[0].Data != "Song "+number {
+			t.Logf("record %d: having number %q", n, number)
+			t.Fail()
+		}
+	}
+
+	for _, n := range []int{-1, 100} {
+		_, _, err := RecordAt(r, "song", n)
+		if err == nil {
+			t.Logf("expected an error for record %d", n)
+			t.Fail()
+		}
+		_, _, err = x.RecordAt(r, n)
+		if err == nil {
+			t.Logf("expected an error for indexed record %d", n)
+			t.Fail()
+		}
+	}
+}
+
+func Test_CountRecords(t *testing.T) {
+
+	for i, c := range []struct {
+		in    string
+		count int
+		err   bool
+	}{
+		{in: parallelInput(123), count: 123},
+		{in: queryInput, count: 5},
+		{in: `<music><song><song/></song><!-- <song/> --></music>`, count: 1},
+		{in: `<music><album/></music>`},
+		{in: `<music><song>`, err: true},
+	} {
+		count, err := CountRecords(strings.NewReader(c.in), "song")
+		if count != c.count || (err != nil) != c.err {
+			t.Logf("failed case %d: having %d and error %v", i+1, count, err)
+			t.Fail()
+		}
+	}
+}
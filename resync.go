@@ -0,0 +1,472 @@
+package xmlx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+)
+
+// resyncWindow is the number of bytes read before an offset to find the markup
+// it may fall within.
+const resyncWindow = 64 * 1024
+
+// lexState is a lexical state of an XML document.
+type lexState int
+
+const (
+	inText lexState = iota
+	inTextBracket
+	inTextBrackets
+	inOpen
+	inBang
+	inBangDash
+	inCDATAOpen
+	inDeclaration
+	inTag
+	inDouble
+	inSingle
+	inComment
+	inCommentDash
+	inCommentDashes
+	inCDATA
+	inCDATABracket
+	inCDATABrackets
+	inPI
+	inPIQuestion
+	dead
+)
+
+// hypothesis is a lexical state the document may be in at a given position, along
+// with the positions from which a scan would find the same tags, as long as the
+// hypothesis holds.
+type hypothesis struct {
+	state lexState
+	n     int        // the progress within "<![CDATA[", or the depth within a declaration
+	texts [][2]int64 // the inclusive intervals of positions where the state is text
+}
+
+// text returns true if the state is outside of any markup.
+func (h *hypothesis) text() bool {
+	return h.state <= inTextBrackets
+}
+
+// step moves the hypothesis to the state following the byte, or kills it if the
+// byte cannot appear in its state.
+func (h *hypothesis) step(c byte) {
+
+	switch h.state {
+	case inText, inTextBracket, inTextBrackets:
+		switch {
+		case c == '<':
+			h.state = inOpen
+		case c == ']' && h.state != inText:
+			h.state = inTextBrackets
+		case c == ']':
+			h.state = inTextBracket
+		case c == '>' && h.state == inTextBrackets:
+			// "]]>" cannot appear in text.
+			h.state = dead
+		default:
+			h.state = inText
+		}
+
+	case inOpen:
+		switch {
+		case c == '!':
+			h.state = inBang
+		case c == '?':
+			h.state = inPI
+		case c == '/' || isNameStart(c) || c == ':':
+			h.state = inTag
+		default:
+			h.state = dead
+		}
+
+	case inBang:
+		switch {
+		case c == '-':
+			h.state = inBangDash
+		case c == '[':
+			h.state, h.n = inCDATAOpen, 0
+		case c >= 'A' && c <= 'Z':
+			h.state, h.n = inDeclaration, 0
+		default:
+			h.state = dead
+		}
+
+	case inBangDash:
+		h.state = dead
+		if c == '-' {
+			h.state = inComment
+		}
+
+	case inCDATAOpen:
+		h.state = dead
+		if c == "CDATA["[h.n] {
+			h.state, h.n = inCDATAOpen, h.n+1
+			if h.n == len("CDATA[") {
+				h.state, h.n = inCDATA, 0
+			}
+		}
+
+	case inDeclaration:
+		switch c {
+		case '[':
+			h.n++
+		case ']':
+			h.n--
+		case '>':
+			if h.n <= 0 {
+				h.state, h.n = inText, 0
+			}
+		}
+
+	case inTag:
+		switch c {
+		case '"':
+			h.state = inDouble
+		case '\'':
+			h.state = inSingle
+		case '>':
+			h.state = inText
+		case '<':
+			h.state = dead
+		}
+
+	case inDouble, inSingle:
+		switch {
+		case c == '<':
+			h.state = dead
+		case c == '"' && h.state == inDouble, c == '\'' && h.state == inSingle:
+			h.state = inTag
+		}
+
+	case inComment, inCommentDash:
+		switch {
+		case c == '-' && h.state == inComment:
+			h.state = inCommentDash
+		case c == '-':
+			h.state = inCommentDashes
+		default:
+			h.state = inComment
+		}
+
+	case inCommentDashes:
+		// "--" cannot appear in comments, except at their end.
+		h.state = dead
+		if c == '>' {
+			h.state = inText
+		}
+
+	case inCDATA, inCDATABracket, inCDATABrackets:
+		switch {
+		case c == ']' && h.state != inCDATA:
+			h.state = inCDATABrackets
+		case c == ']':
+			h.state = inCDATABracket
+		case c == '>' && h.state == inCDATABrackets:
+			h.state = inText
+		default:
+			h.state = inCDATA
+		}
+
+	case inPI, inPIQuestion:
+		switch {
+		case c == '?':
+			h.state = inPIQuestion
+		case c == '>' && h.state == inPIQuestion:
+			h.state = inText
+		default:
+			h.state = inPI
+		}
+	}
+}
+
+// mark records that the hypothesis is at the position.
+func (h *hypothesis) mark(pos int64) {
+	if !h.text() {
+		return
+	}
+	if n := len(h.texts); n > 0 && h.texts[n-1][1] == pos-1 {
+		h.texts[n-1][1] = pos
+		return
+	}
+	h.texts = append(h.texts, [2]int64{pos, pos})
+}
+
+// Resync returns the first position at or after the offset that is outside of
+// any markup, so that the tags found from there are genuine. The offset may fall
+// anywhere, for instance within a tag, an attribute value, a comment, a CDATA
+// section or a processing instruction.
+//
+// Each lexical state the offset may fall within is assumed, and the bytes that
+// follow are read in all of them at once. The states in which a byte cannot appear,
+// such as a "<" within an attribute value or a "]]>" within text, are discarded,
+// until the remaining states agree. Comments, CDATA sections, processing
+// instructions and declarations are only assumed when they are opened within the
+// 64 KiB preceding the offset, and not closed before it.
+func Resync(reader io.ReadSeeker, offset int64) (int64, error) {
+
+	var hypotheses []*hypothesis
+	for _, s := range []lexState{inText, inTag, inDouble, inSingle} {
+		hypotheses = append(hypotheses, &hypothesis{state: s})
+	}
+
+	// Only assume the markup opened and not closed before the offset, including
+	// the markup whose opening straddles the offset.
+	before := offset
+	if before > resyncWindow {
+		before = resyncWindow
+	}
+	_, err := reader.Seek(offset-before, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	window := make([]byte, before+int64(len("<![CDATA[")))
+	n, err := io.ReadFull(reader, window)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	window = window[:n]
+	for _, open := range []string{"<!--", "<![CDATA[", "<?"} {
+		limit := int(before) + len(open) - 1
+		if limit > len(window) {
+			limit = len(window)
+		}
+		i := bytes.LastIndex(window[:limit], []byte(open))
+		if i < 0 {
+			continue
+		}
+		h := &hypothesis{state: inText}
+		for _, c := range window[i:before] {
+			h.step(c)
+		}
+		if h.state != dead && !h.text() {
+			hypotheses = append(hypotheses, h)
+		}
+	}
+
+	// Declarations contain markup of their own, such as the entity declarations
+	// of a DOCTYPE, so that the outermost one not closed before the offset is assumed.
+	limit := int(before) + len("<!A") - 1
+	if limit > len(window) {
+		limit = len(window)
+	}
+	for i := 0; i < limit; {
+		j := declarationIndex(window[i:limit])
+		if j < 0 {
+			break
+		}
+		h := &hypothesis{state: inText}
+		k := i + j
+		for k < int(before) {
+			h.step(window[k])
+			k++
+			if h.state == dead || h.text() {
+				break
+			}
+		}
+		if h.state != dead && !h.text() {
+			hypotheses = append(hypotheses, h)
+			break
+		}
+		if h.state == dead {
+			k = i + j + 1
+		}
+		i = k
+	}
+
+	_, err = reader.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	l := &lexer{r: reader, buf: make([]byte, 0, 4096), base: offset}
+	pos := offset
+	for {
+		for _, h := range hypotheses {
+			h.mark(pos)
+		}
+
+		if p, ok := agree(hypotheses); ok {
+			return p, nil
+		}
+
+		c, ok := l.readByte()
+		if !ok {
+			break
+		}
+		alive := hypotheses[:0]
+		for _, h := range hypotheses {
+			h.step(c)
+			if h.state != dead {
+				alive = append(alive, h)
+			}
+		}
+		hypotheses = alive
+		pos++
+
+		if len(hypotheses) == 0 {
+			return 0, fmt.Errorf("cannot resync at offset %d: invalid markup at offset %d", offset, pos-1)
+		}
+	}
+
+	if l.err != nil && l.err != io.EOF {
+		return 0, l.err
+	}
+
+	// The document cannot end within markup.
+	alive := hypotheses[:0]
+	for _, h := range hypotheses {
+		if h.text() {
+			alive = append(alive, h)
+		}
+	}
+	if p, ok := agree(alive); ok {
+		return p, nil
+	}
+
+	return 0, fmt.Errorf("cannot resync at offset %d: unexpected end of markup", offset)
+}
+
+// declarationIndex returns the index of the first declaration opened in b, or -1
+// if there is none.
+func declarationIndex(b []byte) int {
+	for i := 0; i+2 < len(b); i++ {
+		if b[i] == '<' && b[i+1] == '!' && b[i+2] >= 'A' && b[i+2] <= 'Z' {
+			return i
+		}
+	}
+	return -1
+}
+
+// agree returns the first position where all the hypotheses are outside of any
+// markup, once they are in the same state outside of any markup: from then on,
+// they find the same tags.
+func agree(hypotheses []*hypothesis) (int64, bool) {
+
+	if len(hypotheses) == 0 {
+		return 0, false
+	}
+	first := hypotheses[0]
+	for _, h := range hypotheses {
+		if !h.text() || h.state != first.state || h.n != first.n {
+			return 0, false
+		}
+	}
+
+	// Intersect the intervals of the hypotheses: the current position is part of
+	// all of them.
+	intervals := first.texts
+	for _, h := range hypotheses[1:] {
+		var common [][2]int64
+		i, j := 0, 0
+		for i < len(intervals) && j < len(h.texts) {
+			a, b := intervals[i], h.texts[j]
+			start, stop := a[0], a[1]
+			if b[0] > start {
+				start = b[0]
+			}
+			if b[1] < stop {
+				stop = b[1]
+			}
+			if start <= stop {
+				common = append(common, [2]int64{start, stop})
+			}
+			if a[1] < b[1] {
+				i++
+			} else {
+				j++
+			}
+		}
+		intervals = common
+	}
+
+	return intervals[0][0], true
+}
+
+// chunkFrom returns the start and stop offsets of the first token of the reader,
+// which starts at offset, outside of any markup. The elements opened before the
+// offset are unknown: a token is rejected when one of them, closing within the
+// 64 KiB that follow the token, is a token itself, since the token is then nested
+// in another one rather than at the depth of the tokens.
+func chunkFrom(ctx context.Context, reader io.Reader, token string, offset int64) ([2]int64, error) {
+
+	l := &lexer{r: reader, buf: make([]byte, 0, 64*1024), base: offset}
+
+	// The level counts the elements opened since the offset, and becomes negative
+	// as the elements opened before it are closed. The floor is its lowest value.
+	var depth, level, floor int
+	var mark int64
+	var candidate [2]int64
+	var found bool
+	for {
+		select {
+		case <-ctx.Done():
+			return [2]int64{}, ctx.Err()
+		default:
+		}
+
+		kind, name, start, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return [2]int64{}, err
+		}
+		if found && start > candidate[1]+resyncWindow {
+			return candidate, nil
+		}
+
+		switch kind {
+		case startTag, emptyTag:
+			if kind == startTag {
+				level++
+			}
+			if found {
+				continue
+			}
+			if depth > 0 {
+				if kind == startTag {
+					depth++
+				}
+				continue
+			}
+			if string(name) != token {
+				continue
+			}
+			if kind == startTag {
+				depth, mark = 1, start
+				continue
+			}
+			candidate, found = [2]int64{start, l.offset()}, true
+
+		case endTag:
+			if level == floor {
+				// The tag closes an element opened before the offset, which
+				// encloses the candidate.
+				floor--
+				if found && string(name) == token {
+					found = false
+				}
+			}
+			level--
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				candidate, found = [2]int64{mark, l.offset()}, true
+			}
+		}
+	}
+
+	switch {
+	case found:
+		return candidate, nil
+	case depth > 0:
+		return [2]int64{}, io.ErrUnexpectedEOF
+	}
+
+	return [2]int64{}, io.EOF
+}
@@ -0,0 +1,103 @@
+package xmlx
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_Resync(t *testing.T) {
+
+	for label, c := range map[string]struct {
+		in     string
+		offset int
+		out    int
+	}{
+		"start":               {in: `<a><song>1</song></a>`, offset: 0, out: 0},
+		"before tag":          {in: `<a><song>1</song></a>`, offset: 3, out: 3},
+		"within text":         {in: `<a><song>12</song></a>`, offset: 10, out: 10},
+		"within name":         {in: `<a><song>1</song></a>`, offset: 5, out: 9},
+		"within attribute":    {in: `<a><song t="x > y">1</song></a>`, offset: 13, out: 19},
+		"within comment":      {in: `<a><!-- <song>1</song> --><song>2</song></a>`, offset: 8, out: 26},
+		"within CDATA":        {in: `<a><song><![CDATA[ <song>1</song> ]]></song></a>`, offset: 19, out: 37},
+		"within PI":           {in: `<a><?pi <song>1</song> ?><song>2</song></a>`, offset: 8, out: 25},
+		"closed comment":      {in: `<a><!-- x --><song>-- not a comment</song></a>`, offset: 22, out: 22},
+		"brackets in text":    {in: `<a><song>a]]b</song></a>`, offset: 10, out: 10},
+		"within declaration":  {in: `<!DOCTYPE a [<!ELEMENT a ANY>]><a/>`, offset: 15, out: 31},
+		"within end of input": {in: `<a><song>1</song></a>`, offset: 19, out: 21},
+	} {
+		out, err := Resync(strings.NewReader(c.in), int64(c.offset))
+		if err != nil {
+			t.Log("on case", label)
+			t.Log("unexpected error", err)
+			t.Fail()
+			continue
+		}
+		if out != int64(c.out) {
+			t.Log("on case", label)
+			t.Logf("expected: %d", c.out)
+			t.Logf("having: %d", out)
+			t.Fail()
+		}
+	}
+}
+
+func Test_ChunkResync(t *testing.T) {
+
+	for label, in := range map[string]string{
+		"cdata": `<music><songs><song><name><![CDATA[<song>fake</song> ]]> ]] ]></name></song>` +
+			`<song><![CDATA[</song><song a="1">]]></song></songs></music>`,
+		"comments": `<music><!-- <song>fake</song> - > --><songs><song>1</song><!--<song/>-->` +
+			`<song><!-- </song> --></song></songs></music>`,
+		"attributes": `<music><songs><song title="a > b" alt='"&lt;song>"'>1</song>` +
+			`<song title='>>'/><song a=">" b='>'>3</song></songs></music>`,
+		"instructions": `<?xml version="1.0"?><music><?song <song>fake</song>?><songs>` +
+			`<song><?x ?? > <song> ?></song><song>2</song></songs></music>`,
+		"declaration": `<?xml version="1.0"?><!DOCTYPE music [<!ELEMENT song (#PCDATA)><!-- <song> -->` +
+			`<!ENTITY e "<song>">]><music><song>1</song><song>2</song></music>`,
+		"entity": `<!DOCTYPE music [<!ENTITY e "<song>">]><music><song>1</song><song>2</song></music>`,
+		"nested": `<music><song><song>in</song><x/><song/></song><song>b<song>c</song></song>` +
+			`<y><song/></y><song><a><song><song/></song></a></song></music>`,
+	} {
+		offsets, err := TokenOffsets(strings.NewReader(in), "song")
+		if err != nil {
+			t.Log("on case", label)
+			t.Log("unexpected error", err)
+			t.Fail()
+			continue
+		}
+
+		// From any offset, the next genuine token must be found.
+		for offset := 0; offset < len(in); offset++ {
+			expected := [2]int64{}
+			expectedErr := io.EOF
+			for _, o := range offsets {
+				if o[0] >= int64(offset) {
+					expected, expectedErr = o, nil
+					break
+				}
+			}
+
+			segment, err := Chunk(strings.NewReader(in), "song", int64(offset))
+			if err != expectedErr || segment != expected {
+				t.Log("on case", label, "at offset", offset)
+				t.Logf("expected: %v %v", expected, expectedErr)
+				t.Logf("having: %v %v", segment, err)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func Test_ChunkNested(t *testing.T) {
+
+	in := `<r><item><item>in</item><x/></item><item>b</item></r>`
+	for _, offset := range []int64{4, 7, 9} {
+		segment, err := Chunk(strings.NewReader(in), "item", offset)
+		if err != nil || in[segment[0]:segment[1]] != `<item>b</item>` {
+			t.Log("at offset", offset)
+			t.Logf("having: %v %v", segment, err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package xmlx
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// RecordScanner reads the elements named after a token from a stream, one at
+// a time, in a single forward pass. Only the current element and the namespace
+// declarations of its ancestors are kept in memory, so the stream does not need
+// to be seekable.
+//
+//	scanner := NewRecordScanner(os.Stdin, "song")
+//	for scanner.Next() {
+//		song := scanner.Node()
+//		// do stuff...
+//	}
+//	if err := scanner.Err(); err != nil {
+//		// handle error...
+//	}
+type RecordScanner struct {
+	decoder *xml.Decoder
+	token   string
+	scopes  []scope
+	node    Node
+	offset  [2]int64
+	err     error
+}
+
+// NewRecordScanner returns a new scanner reading the elements named after the
+// token from the reader. As in Chunk, the token is matched against local names.
+func NewRecordScanner(r io.Reader, token string) *RecordScanner {
+	return &RecordScanner{decoder: xml.NewDecoder(r), token: token, scopes: []scope{nil}}
+}
+
+// Next decodes the next element matching the token. It returns false at the end
+// of the stream, or if an error occurred. Elements nested in a matching element
+// are part of it, and are not returned on their own.
+func (s *RecordScanner) Next() bool {
+
+	if s.err != nil {
+		return false
+	}
+
+	for {
+		start := s.decoder.InputOffset()
+		t, err := s.decoder.Token()
+		if err != nil {
+			s.node, s.offset = Node{}, [2]int64{}
+			s.err = err
+			return false
+		}
+
+		switch elt := t.(type) {
+
+		case xml.StartElement:
+			current := s.scopes[len(s.scopes)-1]
+			if elt.Name.Local != s.token {
+				var n Node
+				for _, a := range elt.Attr {
+					switch {
+					case a.Name.Space == "xmlns":
+						n.declare(a.Name.Local, a.Value)
+					case a.Name.Space == "" && a.Name.Local == "xmlns":
+						n.declare("", a.Value)
+					}
+				}
+				s.scopes = append(s.scopes, current.with(n))
+				continue
+			}
+
+			var n Node
+			err := n.unmarshal(s.decoder, elt, current)
+			if err != nil {
+				s.node, s.offset = Node{}, [2]int64{}
+				s.err = err
+				return false
+			}
+
+			// Copy the declarations of the ancestors, so that the node is self-contained.
+			for i := len(current) - 1; i >= 0; i-- {
+				if _, ok := n.Namespaces[current[i].prefix]; !ok {
+					n.declare(current[i].prefix, current[i].uri)
+				}
+			}
+
+			s.node = n
+			s.offset = [2]int64{start, s.decoder.InputOffset()}
+			return true
+
+		case xml.EndElement:
+			if len(s.scopes) > 1 {
+				s.scopes = s.scopes[:len(s.scopes)-1]
+			}
+		}
+	}
+}
+
+// Node returns the element decoded by the last call to Next. The namespace
+// declarations of its ancestors are copied into the node, so that it can be
+// marshalled or queried on its own.
+func (s *RecordScanner) Node() Node {
+	return s.node
+}
+
+// Offset returns the start and stop position of the element decoded by the last
+// call to Next, as Chunk does.
+func (s *RecordScanner) Offset() [2]int64 {
+	return s.offset
+}
+
+// Err returns the first error encountered by the scanner, except io.EOF.
+func (s *RecordScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
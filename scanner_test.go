@@ -0,0 +1,95 @@
+package xmlx
+
+import (
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func Test_RecordScanner(t *testing.T) {
+
+	scanner := NewRecordScanner(iotest.OneByteReader(strings.NewReader(queryInput)), "song")
+
+	var names []string
+	var offset int64
+	for scanner.Next() {
+		n := scanner.Node()
+		names = append(names, n.Nodes[0].Data)
+
+		// Offsets match the ones returned by Chunk.
+		segment, err := Chunk(strings.NewReader(queryInput), "song", offset)
+		if err != nil || segment != scanner.Offset() {
+			t.Logf("having offset %v, expected %v (%v)", scanner.Offset(), segment, err)
+			t.Fail()
+		}
+		offset = segment[1]
+
+		if !strings.HasPrefix(queryInput[segment[0]:segment[1]], "<song number=\""+n.Attrs["number"]+"\"") {
+			t.Logf("unexpected segment %q", queryInput[segment[0]:segment[1]])
+			t.Fail()
+		}
+	}
+	if scanner.Err() != nil {
+		t.Log("unexpected error", scanner.Err())
+		t.Fail()
+	}
+
+	expected := []string{"Enter Sandman", "Sad but True", "The Unforgiven", "Ain't My Bitch", "2 X 4"}
+	if strings.Join(names, "|") != strings.Join(expected, "|") {
+		t.Logf("having: %q", names)
+		t.Logf("expected: %q", expected)
+		t.Fail()
+	}
+
+	if scanner.Next() || scanner.Err() != nil {
+		t.Log("expected the scanner to be done")
+		t.Fail()
+	}
+}
+
+func Test_RecordScannerNamespaces(t *testing.T) {
+
+	in := `<root xmlns:a="urn:a"><a:list xmlns="urn:d"><item a:id="1"/></a:list><item a:id="2" xmlns:a="urn:b"><item/></item></root>`
+	scanner := NewRecordScanner(strings.NewReader(in), "item")
+
+	var having []string
+	for scanner.Next() {
+		n := scanner.Node()
+		for k, v := range n.Attrs {
+			having = append(having, n.Space+" "+k+"="+v)
+		}
+		m := n.Map()
+		having = append(having, m["#attr.a:id"], n.Namespaces["a"])
+	}
+	if scanner.Err() != nil {
+		t.Log("unexpected error", scanner.Err())
+		t.Fail()
+	}
+
+	expected := []string{"urn:d {urn:a}id=1", "1", "urn:a", " {urn:b}id=2", "2", "urn:b"}
+	if strings.Join(having, "|") != strings.Join(expected, "|") {
+		t.Logf("having: %q", having)
+		t.Logf("expected: %q", expected)
+		t.Fail()
+	}
+}
+
+func Test_RecordScannerInvalid(t *testing.T) {
+
+	scanner := NewRecordScanner(strings.NewReader(`<root><item>1</item><item>2</root>`), "item")
+
+	var count int
+	for scanner.Next() {
+		count++
+	}
+
+	if count != 1 || scanner.Err() == nil {
+		t.Logf("having %d items and error %v", count, scanner.Err())
+		t.Fail()
+	}
+
+	if scanner.Next() || scanner.Offset() != [2]int64{} {
+		t.Log("expected the scanner to stop after an error")
+		t.Fail()
+	}
+}
@@ -0,0 +1,233 @@
+package xmlx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type is the type of a value of a typed map.
+type Type int
+
+// Types of the values of a typed map. Values of type IntType are int64, values
+// of type FloatType are float64, values of type BoolType are bool and values of
+// type TimeType are time.Time, parsed from RFC 3339.
+const (
+	StringType Type = iota
+	IntType
+	FloatType
+	BoolType
+	TimeType
+)
+
+// typeNames are the names of the types, as written in configurations.
+var typeNames = [...]string{"string", "int", "float", "bool", "time"}
+
+// String returns the name of the type.
+func (t Type) String() string {
+	if t < 0 || int(t) >= len(typeNames) {
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+	return typeNames[t]
+}
+
+// MarshalText returns the name of the type.
+func (t Type) MarshalText() ([]byte, error) {
+	if t < 0 || int(t) >= len(typeNames) {
+		return nil, fmt.Errorf("invalid type %d", int(t))
+	}
+	return []byte(typeNames[t]), nil
+}
+
+// UnmarshalText sets the type from its name. An empty name is a string.
+func (t *Type) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*t = StringType
+		return nil
+	}
+	for i, name := range typeNames {
+		if name == string(text) {
+			*t = Type(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid type %q", text)
+}
+
+// TypeRule forces the type of the values whose key matches the pattern.
+type TypeRule struct {
+
+	// The pattern of the keys, where '*' matches any sequence of characters
+	Pattern string
+
+	// The type of the values
+	Type Type
+}
+
+// TypeOptions defines how TypedMap converts the values of a flatten representation.
+type TypeOptions struct {
+
+	// The syntax of the keys
+	MapOptions
+
+	// Infer converts the data and attributes that look like integers, floats,
+	// booleans or RFC 3339 times into such values. Other values remain strings.
+	Infer bool
+
+	// The rules forcing the type of values. The first rule matching a key
+	// applies, whether inference is enabled or not.
+	Rules []TypeRule
+}
+
+// TypedMap returns a flatten representation of the node, as MapWith does, with
+// data and attribute values converted according to the options. Names always
+// remain strings. An error is returned if a value cannot be converted into the
+// type forced by a rule.
+func (n Node) TypedMap(o TypeOptions) (map[string]interface{}, error) {
+
+	m := n.MapWith(o.MapOptions)
+	keys := o.MapOptions.withDefaults()
+
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if !keys.OmitName && (k == keys.NameKey || strings.HasSuffix(k, keys.Separator+keys.NameKey)) {
+			out[k] = v
+			continue
+		}
+
+		rule, ok := o.rule(k)
+		switch {
+		case ok:
+			value, err := convert(v, rule)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for key %q: %s", k, err)
+			}
+			out[k] = value
+		case o.Infer:
+			out[k] = infer(v)
+		default:
+			out[k] = v
+		}
+	}
+
+	return out, nil
+}
+
+// rule returns the type forced by the first rule matching the key.
+func (o TypeOptions) rule(key string) (Type, bool) {
+	for _, r := range o.Rules {
+		if matchPattern(r.Pattern, key) {
+			return r.Type, true
+		}
+	}
+	return StringType, false
+}
+
+// convert converts the value into the given type. Surrounding spaces are
+// ignored, except for strings.
+func convert(value string, t Type) (interface{}, error) {
+
+	v := strings.TrimSpace(value)
+	switch t {
+	case IntType:
+		return strconv.ParseInt(v, 10, 64)
+	case FloatType:
+		return strconv.ParseFloat(v, 64)
+	case BoolType:
+		return strconv.ParseBool(v)
+	case TimeType:
+		return time.Parse(time.RFC3339, v)
+	}
+
+	return value, nil
+}
+
+// infer converts the value into an integer, a float, a boolean or a time if it
+// looks like one, and returns it unchanged otherwise. Numbers having leading
+// zeros, such as zip codes, remain strings.
+func infer(value string) interface{} {
+
+	v := strings.TrimSpace(value)
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(i, 10) == v {
+		return i
+	}
+	if isDecimal(v) {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	if v == "true" || v == "false" {
+		return v == "true"
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t
+	}
+
+	return value
+}
+
+// isDecimal returns true if s is a decimal number without leading zeros, such
+// as "-2.41" or "1e6".
+func isDecimal(s string) bool {
+
+	i := 0
+	digits := func() int {
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i - start
+	}
+
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+	if i+1 < len(s) && s[i] == '0' && s[i+1] >= '0' && s[i+1] <= '9' {
+		return false
+	}
+	n := digits()
+	if i < len(s) && s[i] == '.' {
+		i++
+		n += digits()
+	}
+	if n == 0 {
+		return false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		if i < len(s) && (s[i] == '-' || s[i] == '+') {
+			i++
+		}
+		if digits() == 0 {
+			return false
+		}
+	}
+
+	return i == len(s)
+}
+
+// matchPattern returns true if s matches the pattern, where '*' matches any
+// sequence of characters.
+func matchPattern(pattern, s string) bool {
+
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
@@ -0,0 +1,161 @@
+package xmlx
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_NodeTypedMap(t *testing.T) {
+
+	in := Node{
+		Name: "Paris",
+		Attrs: map[string]string{
+			"zip":     "075",
+			"capital": "true",
+		},
+		Nodes: []Node{
+			{
+				Name: "founded",
+				Data: "2019-08-10T12:00:00Z",
+			},
+			{
+				Name: "geo",
+				Nodes: []Node{
+					{
+						Name: "lat",
+						Data: "-2.41",
+					},
+					{
+						Name: "long",
+						Data: "13",
+					},
+				},
+			},
+			{
+				Name: "population",
+				Data: " 2187526 ",
+			},
+			{
+				Name: "true",
+				Data: "nan",
+			},
+		},
+	}
+
+	founded := time.Date(2019, 8, 10, 12, 0, 0, 0, time.UTC)
+
+	for i, c := range []struct {
+		options TypeOptions
+		out     map[string]interface{}
+		err     bool
+	}{
+		{
+			options: TypeOptions{},
+			out: map[string]interface{}{
+				"#name":                        "Paris",
+				"#attr.zip":                    "075",
+				"#attr.capital":                "true",
+				"#nodes.founded.#name":         "founded",
+				"#nodes.founded.#data":         "2019-08-10T12:00:00Z",
+				"#nodes.geo.#name":             "geo",
+				"#nodes.geo.#nodes.lat.#name":  "lat",
+				"#nodes.geo.#nodes.lat.#data":  "-2.41",
+				"#nodes.geo.#nodes.long.#name": "long",
+				"#nodes.geo.#nodes.long.#data": "13",
+				"#nodes.population.#name":      "population",
+				"#nodes.population.#data":      " 2187526 ",
+				"#nodes.true.#name":            "true",
+				"#nodes.true.#data":            "nan",
+			},
+		},
+		{
+			options: TypeOptions{
+				Infer: true,
+				Rules: []TypeRule{
+					{Pattern: "#nodes.geo.*.#data", Type: FloatType},
+				},
+			},
+			out: map[string]interface{}{
+				"#name":                        "Paris",
+				"#attr.zip":                    "075",
+				"#attr.capital":                true,
+				"#nodes.founded.#name":         "founded",
+				"#nodes.founded.#data":         founded,
+				"#nodes.geo.#name":             "geo",
+				"#nodes.geo.#nodes.lat.#name":  "lat",
+				"#nodes.geo.#nodes.lat.#data":  -2.41,
+				"#nodes.geo.#nodes.long.#name": "long",
+				"#nodes.geo.#nodes.long.#data": float64(13),
+				"#nodes.population.#name":      "population",
+				"#nodes.population.#data":      int64(2187526),
+				"#nodes.true.#name":            "true",
+				"#nodes.true.#data":            "nan",
+			},
+		},
+		{
+			options: TypeOptions{
+				MapOptions: MapOptions{Separator: "/", AttrPrefix: "@", OmitName: true, OmitNodePrefix: true, OmitDataKey: true},
+				Rules: []TypeRule{
+					{Pattern: "@zip", Type: IntType},
+					{Pattern: "population", Type: IntType},
+					{Pattern: "*", Type: StringType},
+				},
+				Infer: true,
+			},
+			out: map[string]interface{}{
+				"@zip":       int64(75),
+				"@capital":   "true",
+				"founded":    "2019-08-10T12:00:00Z",
+				"geo/lat":    "-2.41",
+				"geo/long":   "13",
+				"population": int64(2187526),
+				"true":       "nan",
+			},
+		},
+		{
+			options: TypeOptions{
+				Rules: []TypeRule{
+					{Pattern: "*.#data", Type: IntType},
+				},
+			},
+			err: true,
+		},
+	} {
+		out, err := in.TypedMap(c.options)
+		if (err != nil) != c.err {
+			t.Logf("failed case %d: unexpected error %v", i+1, err)
+			t.Fail()
+			continue
+		}
+
+		if !c.err && !reflect.DeepEqual(out, c.out) {
+			t.Logf("failed case %d", i+1)
+			t.Logf("expecting: %v", c.out)
+			t.Logf("having: %v", out)
+			t.Fail()
+		}
+	}
+}
+
+func Test_matchPattern(t *testing.T) {
+
+	for i, c := range []struct {
+		pattern string
+		in      string
+		out     bool
+	}{
+		{pattern: "#data", in: "#data", out: true},
+		{pattern: "#data", in: "#nodes.a.#data", out: false},
+		{pattern: "*.#data", in: "#nodes.a.#data", out: true},
+		{pattern: "#nodes.*.#attr.*", in: "#nodes.a.#attr.b", out: true},
+		{pattern: "#nodes.*.#attr.*", in: "#nodes.a.#data", out: false},
+		{pattern: "a*a", in: "a", out: false},
+		{pattern: "*", in: "", out: true},
+	} {
+		if matchPattern(c.pattern, c.in) != c.out {
+			t.Logf("failed case %d", i+1)
+			t.Fail()
+		}
+	}
+}